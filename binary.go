@@ -0,0 +1,115 @@
+package gack
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Binary is a program built by the project.  Sections holds per binary
+// overrides of config sections, for instance a binary can have its own
+// list of build platforms
+type Binary struct {
+	Name     string                 `yaml:"name" desc:"Name of the binary"`
+	Path     string                 `yaml:"path,omitempty" desc:"Path to the binary's main package, defaults to the project root"`
+	Package  string                 `yaml:"package,omitempty" desc:"Package the binary is bundled in, defaults to package_name"`
+	Sections map[string]interface{} `yaml:",inline"`
+}
+
+// Executables returns every binary built by the project with defaults
+// filled in.  A binary's path defaults to the project root and its package
+// defaults to the project's package name.  Projects that don't list any
+// binaries build a single binary named after the package
+func (c *Config) Executables() []Binary {
+	binaries := c.Binaries
+	if len(binaries) == 0 {
+		binaries = []Binary{{Name: c.PackageName}}
+	}
+
+	executables := []Binary{}
+	for _, binary := range binaries {
+		if binary.Path == "" {
+			binary.Path = "./"
+		}
+
+		if binary.Package == "" {
+			binary.Package = c.PackageName
+		}
+		executables = append(executables, binary)
+	}
+	return executables
+}
+
+// Executable returns the named binary
+func (c *Config) Executable(name string) (Binary, bool) {
+	for _, binary := range c.Executables() {
+		if binary.Name == name {
+			return binary, true
+		}
+	}
+	return Binary{}, false
+}
+
+// Packages returns the binaries in each package, keyed by package name
+func (c *Config) Packages() map[string][]Binary {
+	packages := make(map[string][]Binary)
+	for _, binary := range c.Executables() {
+		packages[binary.Package] = append(packages[binary.Package], binary)
+	}
+	return packages
+}
+
+// GetBinary decodes the named section of the config into output, and then
+// decodes the binary's overrides of the section over that.  Maps and lists
+// in the overrides replace the section's values rather than being merged
+// with them, so a binary can build for fewer platforms
+func (c *Config) GetBinary(binary Binary, name string, output interface{}) error {
+	err := c.Get(name, output)
+	if override, found := binary.Sections[name]; found && (err == nil || err == ErrConfigKeyNotFound) {
+		v := &validator{config: c}
+		if node := lookupNode(c.binaryNode(binary.Name), name); node != nil {
+			v.validate([]string{"binaries", binary.Name, name}, node, reflect.TypeOf(output), "config_name")
+		}
+
+		if err = v.err(); err == nil {
+			clearOverridden(override, output)
+			err = decodeConfig(override, output)
+		}
+	}
+
+	if err != nil && err != ErrConfigKeyNotFound {
+		err = fmt.Errorf("Binary %s: %w", binary.Name, err)
+	}
+	return err
+}
+
+// clearOverridden zeroes the map and slice fields of output that are set
+// in override, since decoding merges into existing maps and slices
+func clearOverridden(override, output interface{}) {
+	keys, ok := override.(map[string]interface{})
+	value := reflect.Indirect(reflect.ValueOf(output))
+	if !ok || value.Kind() != reflect.Struct {
+		return
+	}
+
+	for key := range keys {
+		if field, found := fieldByKey(value.Type(), key, "config_name"); found {
+			if kind := field.Type.Kind(); kind == reflect.Map || kind == reflect.Slice {
+				f := value.FieldByIndex(field.Index)
+				f.Set(reflect.Zero(f.Type()))
+			}
+		}
+	}
+}
+
+func (c *Config) binaryNode(name string) *yaml.Node {
+	if binaries := lookupNode(c.node, "binaries"); binaries != nil {
+		for _, node := range binaries.Content {
+			if value := lookupNode(node, "name"); value != nil && value.Value == name {
+				return node
+			}
+		}
+	}
+	return nil
+}
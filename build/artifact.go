@@ -0,0 +1,72 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/abates/gack"
+)
+
+// OutputDir is the directory that binaries are built into
+var OutputDir = "build"
+
+// DefaultArtifactName is the template used to name built binaries when the
+// config doesn't set artifact_name
+const DefaultArtifactName = "{{ .Binary }}_{{ .OS }}_{{ .Arch }}{{ if .Variant }}-{{ .Variant }}{{ end }}{{ .Ext }}"
+
+// ArtifactData is available to the artifact_name template
+type ArtifactData struct {
+	Binary    string
+	OS        string
+	OSVersion string
+	Arch      string
+	Variant   string
+	Version   string
+
+	// Ext is the executable extension for the OS, including the dot
+	Ext string
+}
+
+// ArtifactName renders the artifact_name template for a binary
+func (c *Config) ArtifactName(binary gack.Binary, version string, platform Platform) (string, error) {
+	data := &ArtifactData{
+		Binary:    binary.Name,
+		OS:        platform.OS,
+		OSVersion: platform.OSVersion,
+		Arch:      platform.Arch,
+		Variant:   platform.Variant,
+		Version:   version,
+		Ext:       platform.Ext(),
+	}
+
+	name := c.ArtifactNameTemplate
+	if name == "" {
+		name = DefaultArtifactName
+	}
+
+	var buffer bytes.Buffer
+	tmpl, err := template.New("artifact_name").Option("missingkey=error").Parse(name)
+	if err == nil {
+		err = tmpl.Execute(&buffer, data)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to render artifact_name: %v", err)
+	}
+	return buffer.String(), nil
+}
+
+// ArtifactPath returns the path of the file that is built for a binary and
+// platform.  Everything that needs to find a built binary
+// should use this rather than constructing the name itself
+func ArtifactPath(config *gack.Config, binary gack.Binary, platform Platform) (string, error) {
+	buildConfig, err := GetConfig(config, binary)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := buildConfig.ArtifactName(binary, config.Version, platform)
+	return filepath.Join(OutputDir, name), err
+}
@@ -0,0 +1,281 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abates/gack"
+)
+
+// Request describes a single binary to be built by a Backend
+type Request struct {
+	Binary   gack.Binary
+	Platform Platform
+	Config   *Config
+
+	// Ldflags are the linker flags rendered from the config
+	Ldflags string
+
+	// Flags are the go toolchain settings for the platform and architecture
+	Flags Flags
+
+	// DryRun requests that the backend display what it would do without
+	// building anything
+	DryRun bool
+
+	// Output is the path of the file the backend should produce
+	Output string
+
+	// Date is the time the build is stamped with
+	Date time.Time
+
+	// Reproducible builds clear the environment variables returned by
+	// Environ and must not add anything that changes between builds
+	Reproducible bool
+}
+
+// Backend compiles binaries for a platform and architecture
+type Backend interface {
+	// Dependencies installs anything the backend needs to build with config
+	Dependencies(config *Config) error
+
+	Build(request *Request) error
+}
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]Backend{
+		"native": &nativeBackend{},
+		"xgo":    &xgoBackend{},
+	}
+)
+
+// RegisterBackend makes a backend available to be selected in the build
+// config under the given name
+func RegisterBackend(name string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = backend
+}
+
+// GetBackend returns the named backend
+func GetBackend(name string) (Backend, error) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if backend, found := backends[name]; found {
+		return backend, nil
+	}
+
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("Unknown build backend %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// xgoBackend cross compiles with the xgo image, which has the go toolchain
+// and C cross compilers.  The image is run the same way the xgo command runs
+// it, with the project mounted at /source and the output at /build
+type xgoBackend struct {
+	// installed are the images that have been installed, keyed by the
+	// runtime and image
+	installed map[ContainerConfig]bool
+}
+
+func (x *xgoBackend) Dependencies(config *Config) (err error) {
+	if !x.installed[config.Container] {
+		gack.Logf("Installing xgo build dependencies\n")
+		if err = config.Container.install(); err == nil {
+			if x.installed == nil {
+				x.installed = make(map[ContainerConfig]bool)
+			}
+			x.installed[config.Container] = true
+		}
+	}
+	return err
+}
+
+// Check makes sure the container runtime is installed and that the image
+// is present
+func (x *xgoBackend) Check(config *Config) error {
+	runtime, err := config.Container.runtime()
+	if err == nil {
+		_, err = execute(nil, runtime, "version")
+	}
+
+	found := false
+	if err == nil {
+		found, err = config.Container.hasImage()
+	}
+
+	if err == nil && !found {
+		err = fmt.Errorf("%s is not present in %s, run gack dependencies", config.Container.Image, runtime)
+	} else if err == nil && !config.Container.Pinned() {
+		gack.Logf("WARNING: %s is not pinned by digest\n", config.Container.Image)
+	}
+	return err
+}
+
+// xgoTarget translates a platform into an xgo target.  xgo only knows
+// about the arm variants
+func xgoTarget(platform Platform) (string, error) {
+	if platform.Variant != "" && platform.Arch != "arm" {
+		return "", fmt.Errorf("xgo cannot build %s, use the native backend for %s variants", platform, platform.Arch)
+	}
+	return platform.String(), nil
+}
+
+// xgoEnv returns the environment of the build in the xgo image.  The image
+// only passes tags and ldflags to go build, so trimpath and gcflags are
+// given to the go command in GOFLAGS
+func xgoEnv(request *Request) ([]string, error) {
+	flags := request.Flags
+	if flags.CgoEnabled != nil && !*flags.CgoEnabled {
+		return nil, fmt.Errorf("xgo always builds %s with cgo, use the native backend to disable it", request.Platform)
+	}
+
+	// the container doesn't inherit the environment, so the variables that
+	// reproducible builds clear are left out
+	env := []string{}
+	for _, variable := range request.Environ() {
+		if !strings.HasSuffix(variable, "=") {
+			env = append(env, variable)
+		}
+	}
+
+	if len(flags.Tags) > 0 {
+		env = append(env, "FLAG_TAGS="+strings.Join(flags.Tags, ","))
+	}
+
+	if request.Ldflags != "" {
+		env = append(env, "FLAG_LDFLAGS="+request.Ldflags)
+	}
+
+	goflags := []string{}
+	if value, found := flags.Env["GOFLAGS"]; found && value != "" {
+		goflags = append(goflags, value)
+	}
+
+	if flags.Trimpath != nil && *flags.Trimpath {
+		goflags = append(goflags, "-trimpath")
+	}
+
+	if flags.Gcflags != "" {
+		// GOFLAGS is split on spaces, without any quoting
+		if strings.ContainsAny(flags.Gcflags, " \t") {
+			return nil, fmt.Errorf("xgo cannot build %s with gcflags %q, which contain spaces", request.Platform, flags.Gcflags)
+		}
+		goflags = append(goflags, "-gcflags="+flags.Gcflags)
+	}
+
+	for _, variable := range flags.env() {
+		if !strings.HasPrefix(variable, "GOFLAGS=") {
+			env = append(env, variable)
+		}
+	}
+
+	if len(goflags) > 0 {
+		env = append(env, "GOFLAGS="+strings.Join(goflags, " "))
+	}
+	return env, nil
+}
+
+func (x *xgoBackend) Build(request *Request) error {
+	target, err := xgoTarget(request.Platform)
+	var runtime, source, dest string
+	var env []string
+	if err == nil {
+		runtime, err = request.Config.Container.runtime()
+	}
+
+	if err == nil {
+		env, err = xgoEnv(request)
+	}
+
+	if err == nil {
+		source, err = filepath.Abs(".")
+	}
+
+	if err == nil {
+		dest, err = filepath.Abs(OutputDir)
+	}
+
+	if err == nil && !request.DryRun {
+		err = os.MkdirAll(dest, 0755)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the image names its output <OUT>-<target>, with an extension on
+	// some platforms
+	out := "tmp_" + request.Binary.Name
+	env = append([]string{
+		"GO111MODULE=on",
+		"TARGETS=" + target,
+		"OUT=" + out,
+		"PACK=" + filepath.ToSlash(filepath.Clean(request.Binary.Path)),
+	}, env...)
+
+	args := []string{"run", "--rm", "-v", source + ":/source", "-v", dest + ":/build"}
+	for _, variable := range env {
+		args = append(args, "-e", variable)
+	}
+	args = append(args, request.Config.Container.Image, request.Binary.Path)
+
+	output, err := run(request, nil, runtime, args...)
+	if cmdErr, ok := err.(*CommandError); ok {
+		cmdErr.Root = "/source/"
+	}
+
+	if err != nil || request.DryRun {
+		return err
+	}
+
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(OutputDir, fmt.Sprintf("%s-%s*", out, request.Platform.OSName()))); err == nil {
+		if len(files) == 1 {
+			err = os.Rename(files[0], request.Output)
+		} else {
+			err = fmt.Errorf("Expected xgo to return only one file, but got %v. xgo printed:\n%s", files, output)
+		}
+	}
+	return err
+}
+
+// nativeBackend builds with the local go toolchain.  Only programs that
+// don't need cgo can be cross compiled this way
+type nativeBackend struct{}
+
+func (n *nativeBackend) Dependencies(*Config) error {
+	_, err := execute(nil, "go", "version")
+	return err
+}
+
+func (n *nativeBackend) Check(config *Config) error {
+	return n.Dependencies(config)
+}
+
+func (n *nativeBackend) Build(request *Request) (err error) {
+	if !request.DryRun {
+		err = os.MkdirAll(filepath.Dir(request.Output), 0755)
+	}
+
+	if err == nil {
+		args := append([]string{"build", "-o", request.Output}, request.Flags.Args()...)
+		if request.Ldflags != "" {
+			args = append(args, "-ldflags", request.Ldflags)
+		}
+		env := append(request.Environ(), request.Platform.GoEnv()...)
+		env = append(env, request.Flags.Environ(false)...)
+		_, err = run(request, env, "go", append(args, request.Binary.Path)...)
+	}
+	return err
+}
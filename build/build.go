@@ -1,36 +1,52 @@
 package build
 
 import (
+	"debug/buildinfo"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
 
 	"github.com/abates/gack"
+	"github.com/abates/gack/command"
 )
 
-type builder struct {
-	hasDependencies bool
-}
+type builder struct{}
 
-type Executor interface {
-	CombinedOutput() ([]byte, error)
-}
+type Config struct {
+	Platforms        map[string][]string `desc:"Architectures to build for each platform"`
+	Backend          string              `yaml:"backend" config_name:"backend" desc:"Backend used to compile binaries" allowed:"xgo,native"`
+	PlatformBackends map[string]string   `yaml:"platform_backends" config_name:"platform_backends" desc:"Backend used for specific platforms, overriding backend"`
+	LdflagsVars      map[string]string   `yaml:"ldflags_vars" config_name:"ldflags_vars" desc:"Variables set when linking, such as main.version: \"{{ .Version }}\". Values are templates with access to the config fields, .Binary, .Platform, .Architecture, .Commit and .Date"`
+	Flags            `yaml:",inline" config_name:",squash"`
+	PlatformFlags    map[string]Flags `yaml:"platform_flags" config_name:"platform_flags" desc:"Flags for a platform (linux) or a platform and architecture (linux/arm-7), overriding the flags above"`
+
+	Container ContainerConfig `yaml:"container" config_name:"container" desc:"Container runtime and image used by the xgo backend"`
+
+	ArtifactNameTemplate string `yaml:"artifact_name" config_name:"artifact_name" desc:"Template for the file names of built binaries with access to .Binary, .OS, .OSVersion, .Arch, .Variant, .Version and .Ext"`
 
-func execCommand(name string, args ...string) Executor {
-	return exec.Command(name, args...)
+	Reproducible bool `yaml:"reproducible" config_name:"reproducible" desc:"Build with -trimpath and a pinned build id, date the build from SOURCE_DATE_EPOCH or the last commit and clear inherited environment variables that change the output"`
 }
 
-var getExecCommand = execCommand
+// GetBackend returns the backend used to build for platform
+func (c *Config) GetBackend(platform string) (Backend, error) {
+	return GetBackend(c.backendName(platform))
+}
 
-type Config struct {
-	Platforms map[string][]string
+func (c *Config) backendName(platform string) string {
+	if platformBackend, found := c.PlatformBackends[platform]; found {
+		return platformBackend
+	}
+	return c.Backend
 }
 
-func GetConfig(mux *gack.Mux) *Config {
-	_, config := DefaultConfig()
-	mux.Config.Get("build", config)
-	return config
+// GetConfig returns the build config for a binary, including the binary's
+// own overrides
+func GetConfig(config *gack.Config, binary gack.Binary) (*Config, error) {
+	name, buildConfig := DefaultConfig()
+	err := config.GetBinary(binary, name, buildConfig)
+	if err == gack.ErrConfigKeyNotFound {
+		err = nil
+	}
+	return buildConfig, err
 }
 
 func DefaultConfig() (string, *Config) {
@@ -40,6 +56,16 @@ func DefaultConfig() (string, *Config) {
 			"windows-10":  []string{"386", "amd64"},
 			"darwin-10.6": []string{"386", "amd64"},
 		},
+		Backend:          "xgo",
+		PlatformBackends: map[string]string{},
+		LdflagsVars:      map[string]string{},
+		PlatformFlags:    map[string]Flags{},
+		Container: ContainerConfig{
+			Runtime: "docker",
+			Image:   "karalabe/xgo-latest",
+		},
+
+		ArtifactNameTemplate: DefaultArtifactName,
 	}
 }
 
@@ -48,78 +74,247 @@ func (b *builder) DefaultConfig() (string, interface{}) {
 }
 
 func (b *builder) clean(*gack.Context) error {
-	fmt.Printf("Cleaning build/*\n")
-	return os.RemoveAll("build/")
+	gack.Logf("Cleaning %s/*\n", OutputDir)
+	return os.RemoveAll(OutputDir)
 }
 
-func (b *builder) dependencies(*gack.Context) (err error) {
-	if !b.hasDependencies {
-		fmt.Printf("Installing build dependencies\n")
-		_, err = b.execute("docker", "pull", "karalabe/xgo-latest")
-		b.hasDependencies = true
+// dependencies installs the dependencies of every backend used to build
+// the project
+func (b *builder) dependencies(ctx *gack.Context) error {
+	for _, binary := range ctx.Config.Executables() {
+		config, err := GetConfig(ctx.Config, binary)
+		if err != nil {
+			return err
+		}
+
+		platforms, err := config.GetPlatforms()
+		if err != nil {
+			return err
+		}
+
+		installed := make(map[string]bool)
+		for _, platform := range platforms {
+			name := config.backendName(platform.OSName())
+			if installed[name] {
+				continue
+			}
+
+			backend, err := GetBackend(name)
+			if err == nil {
+				err = backend.Dependencies(config)
+			}
+
+			if err != nil {
+				return err
+			}
+			installed[name] = true
+		}
 	}
-	return err
+	return nil
 }
 
 func ContextName(ctx *gack.Context) string {
-	return Name(ctx.Param("package"), ctx.Param("platform"), ctx.Param("architecture"))
+	return Name(ctx.Param("binary"), ctx.Param("platform"), ctx.Param("architecture"))
 }
 
+// Name returns the name of the build target for a binary.  The file that is
+// built is named by ArtifactPath
 func Name(pkg, platform, arch string) string {
 	return fmt.Sprintf("%s_%s_%s", pkg, platform, arch)
 }
 
+// binaryBuilder builds a binary listed in the config
+type binaryBuilder struct {
+	*builder
+	binary gack.Binary
+}
+
+func (b *binaryBuilder) Execute(ctx *gack.Context) error {
+	return b.build(ctx, b.binary, false)
+}
+
+func (b *binaryBuilder) Plan(ctx *gack.Context) error {
+	return b.build(ctx, b.binary, true)
+}
+
+// lookupBinary returns the named binary from the config, or a binary built
+// from the project root if the config doesn't list it
+func lookupBinary(config *gack.Config, name string) gack.Binary {
+	binary, found := config.Executable(name)
+	if !found {
+		binary = gack.Binary{Name: name, Path: "./"}
+	}
+	return binary
+}
+
+func (b *builder) contextBinary(ctx *gack.Context) gack.Binary {
+	return lookupBinary(ctx.Config, ctx.Param("binary"))
+}
+
 func (b *builder) Execute(ctx *gack.Context) error {
-	fmt.Printf("Building %s\n", ctx.Target.Subject())
-	target := fmt.Sprintf("--targets=%s/%s", ctx.Param("platform"), ctx.Param("architecture"))
-
-	packageName := fmt.Sprintf("build/tmp_%s", ctx.Param("package"))
-	_, err := b.execute("xgo", target, "-out", packageName, "./")
-	var files []string
-	if files, err = filepath.Glob(fmt.Sprintf("build/tmp_%s-%s*", ctx.Param("package"), ctx.Param("platform"))); err == nil {
-		if len(files) == 1 {
-			err = os.Rename(files[0], fmt.Sprintf("build/%s", ContextName(ctx)))
-		} else {
-			err = fmt.Errorf("Expected xgo to return only one file, but got %v", files)
+	return b.build(ctx, b.contextBinary(ctx), false)
+}
+
+func (b *builder) Plan(ctx *gack.Context) error {
+	return b.build(ctx, b.contextBinary(ctx), true)
+}
+
+func (b *builder) build(ctx *gack.Context, binary gack.Binary, dryRun bool) error {
+	gack.Logf("Building %s\n", ctx.Target.Subject())
+	config, err := GetConfig(ctx.Config, binary)
+	if err != nil {
+		return err
+	}
+
+	platform, err := ParsePlatform(ctx.Param("platform"), ctx.Param("architecture"))
+	if err != nil {
+		return err
+	}
+
+	request := &Request{
+		Binary:   binary,
+		Platform: platform,
+		Config:   config,
+		Flags:    config.GetFlags(platform.OSName(), platform.ArchName()),
+		DryRun:   dryRun,
+		Date:     buildDate(config.Reproducible),
+	}
+
+	backend, err := config.GetBackend(platform.OSName())
+	if err == nil {
+		request.Output, err = ArtifactPath(ctx.Config, binary, platform)
+	}
+
+	if err == nil {
+		request.Ldflags, err = config.Ldflags(newTemplateData(ctx.Config, request))
+	}
+
+	if err == nil && config.Reproducible {
+		request.makeReproducible()
+	}
+
+	if err == nil {
+		err = backend.Build(request)
+		if cmdErr, ok := err.(*CommandError); ok {
+			if aerr := annotate(cmdErr.Diagnostics()); aerr != nil {
+				gack.Logf("Failed to annotate the build errors: %v\n", aerr)
+			}
 		}
 	}
+
+	if err == nil && !dryRun {
+		err = gack.RecordArtifact(gack.Artifact{
+			Path:      request.Output,
+			Kind:      gack.ArtifactBinary,
+			OS:        platform.OS,
+			Arch:      platform.Arch,
+			Variant:   platform.Variant,
+			Version:   ctx.Config.Version,
+			Target:    ctx.Target.Subject(),
+			GoVersion: GoVersion(request.Output),
+		})
+	}
 	return err
 }
 
-func (b builder) execute(name string, args ...string) (string, error) {
-	executor := getExecCommand(name, args...)
-	output, err := executor.CombinedOutput()
-	return string(output), err
+// GoVersion returns the version of the go toolchain that built the binary
+// in filename, or an empty string if it can't be read
+func GoVersion(filename string) string {
+	info, err := buildinfo.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return info.GoVersion
+}
+
+// run executes a command for a build request.  During a dry run the
+// command is only displayed
+func run(request *Request, env []string, name string, args ...string) (string, error) {
+	if request.DryRun {
+		gack.Logf("\t%s\n", formatCommand(env, name, args...))
+		return "", nil
+	}
+	return execute(env, name, args...)
+}
+
+// execute runs a command with env added to the current environment
+func execute(env []string, name string, args ...string) (string, error) {
+	return executeCommand(&command.Command{Name: name, Args: args, Env: env})
+}
+
+// executeCommand runs a command and returns its output.  A command that
+// fails returns a *CommandError
+func executeCommand(cmd *command.Command) (string, error) {
+	output, err := command.Run(cmd)
+	if err != nil {
+		return output, newCommandError(cmd.Name, cmd.Args, output, err)
+	}
+	return output, nil
 }
 
 type Dependency struct {
-	Platform string
-	Arch     string
+	Binary   gack.Binary
+	Platform Platform
 }
 
-func Dependencies(mux *gack.Mux) []Dependency {
+// Dependencies returns every binary, platform and architecture combination
+// that is built by the project
+func Dependencies(mux *gack.Mux) ([]Dependency, error) {
 	dependencies := []Dependency{}
-	config := GetConfig(mux)
+	for _, binary := range mux.Config.Executables() {
+		config, err := GetConfig(mux.Config, binary)
+		if err != nil {
+			return nil, err
+		}
+
+		platforms, err := config.GetPlatforms()
+		if err != nil {
+			return nil, err
+		}
 
-	for platform, archs := range config.Platforms {
-		for _, arch := range archs {
-			dependencies = append(dependencies, Dependency{platform, arch})
+		for _, platform := range platforms {
+			dependencies = append(dependencies, Dependency{binary, platform})
 		}
 	}
 
-	return dependencies
+	return dependencies, nil
 }
 
-func Register(mux *gack.Mux) {
+func Register(mux *gack.Mux) error {
 	b := &builder{}
 
-	pkg := mux.Config.PackageName
+	dependencies, err := Dependencies(mux)
+	for _, dependency := range dependencies {
+		mux.AddDependency("build", fmt.Sprintf("build/%s", Name(dependency.Binary.Name, dependency.Platform.OSName(), dependency.Platform.ArchName())), nil)
+	}
+
+	// binaries in the config get their own targets so that their names
+	// may contain underscores
+	for _, binary := range mux.Config.Executables() {
+		mux.Register(fmt.Sprintf("build/%s_:platform_:architecture", binary.Name), &binaryBuilder{b, binary}, "dependencies/build")
+	}
+
+	mux.Register("build/:binary_:platform_:architecture", b, "dependencies/build")
 
-	for _, dependency := range Dependencies(mux) {
-		mux.AddDependency("build", fmt.Sprintf("build/%s_%s_%s", pkg, dependency.Platform, dependency.Arch), nil)
+	// universal binaries need their own targets since they are not a real
+	// platform and architecture
+	for _, binary := range mux.Config.Executables() {
+		binary := binary
+		builds, uerr := universalDependencies(mux.Config, binary, binary.Name)
+		if err == nil {
+			err = uerr
+		}
+		mux.Register(fmt.Sprintf("build/%s_darwin_universal", binary.Name), &universalBuilder{b, &binary}, builds...)
 	}
 
-	mux.Register("build/:package_:platform_:architecture", b, "dependencies/build")
+	builds, uerr := universalDependencies(mux.Config, gack.Binary{}, ":package")
+	if err == nil {
+		err = uerr
+	}
+	mux.Register("build/:package_darwin_universal", &universalBuilder{b, nil}, builds...)
+	mux.Register("verify-reproducible/:target", verifyReproducible(mux))
 	mux.AddDependency("dependencies", "dependencies/build", gack.ExecuteFunc(b.dependencies))
+	mux.AddDependency("doctor", "doctor/build", gack.ExecuteFunc(b.doctor))
 	mux.AddDependency("clean", "clean/build", gack.ExecuteFunc(b.clean))
+	return err
 }
@@ -0,0 +1,577 @@
+package build
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abates/gack"
+	"github.com/abates/gack/command"
+)
+
+const testProject = `package_name: hello
+version: 1.0.0
+binaries:
+  - name: hello
+build:
+  backend: %s
+  platforms:
+    linux: [amd64]
+`
+
+var gitRevParse = command.Call{
+	Name:     "git",
+	Args:     []string{"rev-parse", "HEAD"},
+	Output:   "fatal: not a git repository (or any of the parent directories): .git\n",
+	ExitCode: 128,
+}
+
+// newTestMux registers the build targets for a project using backend.  The
+// artifacts go to a temporary directory, which is returned
+func newTestMux(t *testing.T, backend string) (*gack.Mux, string) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	outputDir, manifestFile, runner := OutputDir, gack.ManifestFile, command.DefaultRunner
+	t.Cleanup(func() {
+		OutputDir, gack.ManifestFile, command.DefaultRunner = outputDir, manifestFile, runner
+		os.RemoveAll(dir)
+	})
+	OutputDir = filepath.Join(dir, "build")
+	gack.ManifestFile = ""
+
+	filename := filepath.Join(dir, "gack.yml")
+	err = ioutil.WriteFile(filename, []byte(fmt.Sprintf(testProject, backend)), 0644)
+
+	var mux *gack.Mux
+	if err == nil {
+		mux, err = gack.NewMuxFromFile(filename)
+	}
+
+	if err == nil {
+		err = Register(mux)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return mux, dir
+}
+
+// replay executes target, playing back calls instead of running commands
+func replay(t *testing.T, mux *gack.Mux, target string, calls ...command.Call) error {
+	replay := command.NewReplay(calls...)
+	command.DefaultRunner = replay
+	err := mux.Execute(target)
+	if remaining := replay.Remaining(); len(remaining) > 0 {
+		t.Errorf("Expected %v to be run", remaining)
+	}
+	return err
+}
+
+func TestNativeBuild(t *testing.T) {
+	mux, dir := newTestMux(t, "native")
+	err := replay(t, mux, "build/hello_linux_amd64",
+		command.Call{Name: "go", Args: []string{"version"}, Output: "go version go1.20 linux/amd64\n"},
+		gitRevParse,
+		command.Call{
+			Name: "go",
+			Args: []string{"build", "-o", filepath.Join(dir, "build", "hello_linux_amd64"), "./"},
+			Env:  []string{"GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0"},
+		},
+	)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestXgoBuildError(t *testing.T) {
+	mux, dir := newTestMux(t, "xgo")
+	source, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := "main.go:3:2: undefined: foo\n"
+	err = replay(t, mux, "build/hello_linux_amd64",
+		command.Call{Name: "docker", Args: []string{"pull", "karalabe/xgo-latest"}},
+		gitRevParse,
+		command.Call{
+			Name: "docker",
+			Args: []string{
+				"run", "--rm", "-v", source + ":/source", "-v", filepath.Join(dir, "build") + ":/build",
+				"-e", "GO111MODULE=on", "-e", "TARGETS=linux/amd64", "-e", "OUT=tmp_hello", "-e", "PACK=.",
+				"karalabe/xgo-latest", "./",
+			},
+			Output:   output,
+			ExitCode: 2,
+		},
+	)
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected a *CommandError but got %v", err)
+	}
+
+	if cmdErr.ExitCode != 2 {
+		t.Errorf("Expected exit code 2 but got %d", cmdErr.ExitCode)
+	}
+
+	if cmdErr.Output != output {
+		t.Errorf("Expected output %q but got %q", output, cmdErr.Output)
+	}
+
+	expected := []Diagnostic{{File: "main.go", Line: 3, Column: 2, Message: "undefined: foo"}}
+	if diagnostics := cmdErr.Diagnostics(); !reflect.DeepEqual(diagnostics, expected) {
+		t.Errorf("Expected diagnostics %v but got %v", expected, diagnostics)
+	}
+}
+
+func TestXgoEnv(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name     string
+		request  Request
+		expected []string
+		err      bool
+	}{
+		{"defaults", Request{}, []string{}, false},
+		{
+			"flags",
+			Request{
+				Ldflags: "-X 'main.version=1.0 beta'",
+				Flags: Flags{
+					Tags:     []string{"netgo", "osusergo"},
+					Trimpath: &enabled,
+					Gcflags:  "all=-N",
+					Env:      map[string]string{"GOFLAGS": "-mod=mod", "LIST": "a,b"},
+				},
+			},
+			[]string{"FLAG_TAGS=netgo,osusergo", "FLAG_LDFLAGS=-X 'main.version=1.0 beta'", "LIST=a,b", "GOFLAGS=-mod=mod -trimpath -gcflags=all=-N"},
+			false,
+		},
+		{"reproducible", Request{Reproducible: true, Date: time.Unix(0, 0)}, []string{"SOURCE_DATE_EPOCH=0"}, false},
+		{"cgo disabled", Request{Flags: Flags{CgoEnabled: &disabled}}, nil, true},
+		{"gcflags with spaces", Request{Flags: Flags{Gcflags: "all=-N -l"}}, nil, true},
+	}
+
+	for _, test := range tests {
+		env, err := xgoEnv(&test.request)
+		if test.err && err == nil {
+			t.Errorf("%s: Expected an error", test.name)
+		} else if !test.err && err != nil {
+			t.Errorf("%s: Unexpected error: %v", test.name, err)
+		} else if !reflect.DeepEqual(env, test.expected) {
+			t.Errorf("%s: Expected %q but got %q", test.name, test.expected, env)
+		}
+	}
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	mux, dir := newTestMux(t, "native")
+	builds := 0
+	mux.Register("fake", gack.ExecuteFunc(func(*gack.Context) error {
+		builds++
+		deb := filepath.Join(dir, "pkg", "hello.deb")
+		binary := filepath.Join(OutputDir, "hello")
+		err := os.MkdirAll(filepath.Dir(deb), 0755)
+		if err == nil {
+			err = os.MkdirAll(OutputDir, 0755)
+		}
+
+		// the deb is stamped with the build
+		if err == nil {
+			err = ioutil.WriteFile(deb, []byte(fmt.Sprintf("deb %d", builds)), 0644)
+		}
+
+		if err == nil {
+			err = ioutil.WriteFile(binary, []byte("binary"), 0755)
+		}
+
+		if err == nil {
+			err = gack.RecordArtifact(gack.Artifact{Path: deb, Kind: gack.ArtifactDeb})
+		}
+
+		if err == nil {
+			err = gack.RecordArtifact(gack.Artifact{Path: binary, Kind: gack.ArtifactBinary})
+		}
+		return err
+	}))
+
+	err := mux.Execute("verify-reproducible/fake")
+	if err == nil || err.Error() != "1 of 2 artifacts are not reproducible" {
+		t.Errorf("Expected the deb to not be reproducible but got %v", err)
+	}
+
+	if gack.ManifestFile != "" {
+		t.Errorf("Expected the manifest file to be restored but got %q", gack.ManifestFile)
+	}
+}
+
+// writeThin writes a minimal 64 bit Mach-O executable for cpu
+func writeThin(t *testing.T, filename string, cpu macho.Cpu) []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, macho.FileHeader{Magic: macho.Magic64, Cpu: cpu, Type: macho.TypeExec})
+	// the reserved field of 64 bit headers
+	binary.Write(&buffer, binary.LittleEndian, uint32(0))
+	if err := ioutil.WriteFile(filename, buffer.Bytes(), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestWriteUniversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	amd64, arm64 := filepath.Join(dir, "hello_darwin_amd64"), filepath.Join(dir, "hello_darwin_arm64")
+	thin := map[macho.Cpu][]byte{
+		macho.CpuAmd64: writeThin(t, amd64, macho.CpuAmd64),
+		macho.CpuArm64: writeThin(t, arm64, macho.CpuArm64),
+	}
+
+	output := filepath.Join(dir, "universal", "hello_darwin_universal")
+	inputs := map[Platform]string{{OS: "darwin", Arch: "arm64"}: arm64, {OS: "darwin", Arch: "amd64"}: amd64}
+	if err := writeUniversal(output, inputs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	fat, err := macho.OpenFat(output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer fat.Close()
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cpus := []macho.Cpu{}
+	for _, arch := range fat.Arches {
+		cpus = append(cpus, arch.Cpu)
+		if arch.Offset%(1<<arch.Align) != 0 {
+			t.Errorf("%v: Expected offset %d to be aligned to %d", arch.Cpu, arch.Offset, 1<<arch.Align)
+		}
+
+		if slice := data[arch.Offset : arch.Offset+arch.Size]; !bytes.Equal(slice, thin[arch.Cpu]) {
+			t.Errorf("%v: Expected slice %x but got %x", arch.Cpu, thin[arch.Cpu], slice)
+		}
+
+		if arch.Type != macho.TypeExec {
+			t.Errorf("%v: Expected an executable but got %v", arch.Cpu, arch.Type)
+		}
+	}
+
+	// slices are sorted by cpu type
+	if expected := []macho.Cpu{macho.CpuAmd64, macho.CpuArm64}; !reflect.DeepEqual(cpus, expected) {
+		t.Errorf("Expected cpus %v but got %v", expected, cpus)
+	}
+
+	if offset := fat.Arches[1].Offset; offset != 1<<14 {
+		t.Errorf("Expected the arm64 slice at a 16K page but got offset %d", offset)
+	}
+
+	text := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(text, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		inputs map[Platform]string
+	}{
+		{"wrong cpu", map[Platform]string{{OS: "darwin", Arch: "arm64"}: amd64}},
+		{"universal input", map[Platform]string{{OS: "darwin", Arch: "amd64"}: output}},
+		{"not mach-o", map[Platform]string{{OS: "darwin", Arch: "amd64"}: text}},
+		{"unsupported arch", map[Platform]string{{OS: "darwin", Arch: "ppc64"}: amd64}},
+	}
+
+	for _, test := range tests {
+		if err := writeUniversal(filepath.Join(dir, "out"), test.inputs); err == nil {
+			t.Errorf("%s: Expected an error", test.name)
+		}
+	}
+}
+
+func TestParseDistList(t *testing.T) {
+	platforms, err := parseDistList(`[{"GOOS": "linux", "GOARCH": "amd64", "FirstClass": true}, {"GOOS": "openbsd", "GOARCH": "mips64"}]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, platform := range []string{"linux/amd64", "openbsd/mips64", "darwin/386"} {
+		if !platforms[platform] {
+			t.Errorf("Expected %s to be listed", platform)
+		}
+	}
+
+	for _, output := range []string{"", "[]", "linux/amd64\n"} {
+		if _, err := parseDistList(output); err == nil {
+			t.Errorf("%q: Expected an error", output)
+		}
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		arch     string
+		expected Platform
+		env      []string
+		err      string
+	}{
+		{"linux", "amd64", Platform{OS: "linux", Arch: "amd64"}, []string{"GOOS=linux", "GOARCH=amd64"}, ""},
+		{"darwin-10.6", "amd64", Platform{OS: "darwin", OSVersion: "10.6", Arch: "amd64"}, []string{"GOOS=darwin", "GOARCH=amd64"}, ""},
+		{"linux", "arm-7", Platform{OS: "linux", Arch: "arm", Variant: "7"}, []string{"GOOS=linux", "GOARCH=arm", "GOARM=7"}, ""},
+		{"linux", "amd64-v3", Platform{OS: "linux", Arch: "amd64", Variant: "v3"}, []string{"GOOS=linux", "GOARCH=amd64", "GOAMD64=v3"}, ""},
+		{"linux", "mips64le-softfloat", Platform{OS: "linux", Arch: "mips64le", Variant: "softfloat"}, []string{"GOOS=linux", "GOARCH=mips64le", "GOMIPS64=softfloat"}, ""},
+		{"openbsd", "mips64", Platform{OS: "openbsd", Arch: "mips64"}, []string{"GOOS=openbsd", "GOARCH=mips64"}, ""},
+		{"darwin", "386", Platform{OS: "darwin", Arch: "386"}, []string{"GOOS=darwin", "GOARCH=386"}, ""},
+		{"linux", "sparc", Platform{}, nil, "Unknown platform linux/sparc"},
+		{"windows", "arm64-v8", Platform{}, nil, "Architecture arm64 does not have variants, but got arm64-v8"},
+		{"linux", "arm-8", Platform{}, nil, `Unknown arm variant "8", expected one of 5, 6, 7`},
+	}
+
+	for _, test := range tests {
+		platform, err := ParsePlatform(test.platform, test.arch)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s/%s: Expected error %q but got %v", test.platform, test.arch, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s/%s: Unexpected error: %v", test.platform, test.arch, err)
+		} else if platform != test.expected {
+			t.Errorf("%s/%s: Expected %+v but got %+v", test.platform, test.arch, test.expected, platform)
+		} else if name := platform.String(); name != test.platform+"/"+test.arch {
+			t.Errorf("%s/%s: Expected the name to be %s but got %s", test.platform, test.arch, test.platform+"/"+test.arch, name)
+		} else if env := platform.GoEnv(); !reflect.DeepEqual(env, test.env) {
+			t.Errorf("%s/%s: Expected env %q but got %q", test.platform, test.arch, test.env, env)
+		}
+	}
+}
+
+func TestPlatformNames(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		os       string
+		arch     string
+		ext      string
+	}{
+		{Platform{OS: "linux", Arch: "amd64"}, "linux", "amd64", ""},
+		{Platform{OS: "darwin", OSVersion: "10.6", Arch: "arm64"}, "darwin-10.6", "arm64", ""},
+		{Platform{OS: "windows", Arch: "arm", Variant: "7"}, "windows", "arm-7", ".exe"},
+		{universal, "darwin", "universal", ""},
+	}
+
+	for _, test := range tests {
+		if os := test.platform.OSName(); os != test.os {
+			t.Errorf("%v: Expected OS name %q but got %q", test.platform, test.os, os)
+		}
+
+		if arch := test.platform.ArchName(); arch != test.arch {
+			t.Errorf("%v: Expected architecture name %q but got %q", test.platform, test.arch, arch)
+		}
+
+		if ext := test.platform.Ext(); ext != test.ext {
+			t.Errorf("%v: Expected extension %q but got %q", test.platform, test.ext, ext)
+		}
+	}
+}
+
+func TestLdflags(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{"1.0.0", "-X main.value=1.0.0", false},
+		{"1.0 beta", "-X 'main.value=1.0 beta'", false},
+		{`say "hi" there`, `-X 'main.value=say "hi" there'`, false},
+		{"it's a beta", `-X "main.value=it's a beta"`, false},
+		{"{{ .PackageName }}'s \"beta\"", "", true},
+	}
+
+	for _, test := range tests {
+		config := &Config{LdflagsVars: map[string]string{"main.value": test.value}}
+		ldflags, err := config.Ldflags(&TemplateData{Config: gack.NewConfig()})
+		if test.err && err == nil {
+			t.Errorf("%s: Expected an error", test.value)
+		} else if !test.err && err != nil {
+			t.Errorf("%s: Unexpected error: %v", test.value, err)
+		} else if ldflags != test.expected {
+			t.Errorf("%s: Expected %q but got %q", test.value, test.expected, ldflags)
+		}
+	}
+}
+
+// TestLdflagsLink checks that the go command splits the quoted flags back
+// into the original values
+func TestLdflagsLink(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module hello\n"), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nvar a, b string\n\nfunc main() { println(a + \"|\" + b) }\n"), 0644)
+	}
+
+	values := map[string]string{"main.a": "it's a beta", "main.b": `say "hi" there`}
+	var ldflags string
+	if err == nil {
+		ldflags, err = (&Config{LdflagsVars: values}).Ldflags(&TemplateData{Config: gack.NewConfig()})
+	}
+
+	var output []byte
+	if err == nil {
+		cmd := exec.Command("go", "run", "-ldflags", ldflags, ".")
+		cmd.Dir = dir
+		output, err = cmd.CombinedOutput()
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, output)
+	}
+
+	if expected := values["main.a"] + "|" + values["main.b"] + "\n"; string(output) != expected {
+		t.Errorf("Expected %q but got %q", expected, output)
+	}
+}
+
+func TestAnnotateGitHub(t *testing.T) {
+	stdout, github, gitlab := gack.Stdout, os.Getenv("GITHUB_ACTIONS"), os.Getenv("GITLAB_CI")
+	defer func() {
+		gack.Stdout = stdout
+		os.Setenv("GITHUB_ACTIONS", github)
+		os.Setenv("GITLAB_CI", gitlab)
+	}()
+
+	var buffer bytes.Buffer
+	gack.Stdout = &buffer
+	os.Setenv("GITHUB_ACTIONS", "true")
+	os.Setenv("GITLAB_CI", "")
+
+	err := annotate([]Diagnostic{
+		{File: "main.go", Line: 3, Column: 2, Message: "undefined: foo"},
+		{File: "dir,v2/100%:x.go", Line: 1, Column: 1, Message: "50% done\nnext"},
+	})
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expected := "::error file=main.go,line=3,col=2::undefined: foo\n" +
+		"::error file=dir%2Cv2/100%25%3Ax.go,line=1,col=1::50%25 done%0Anext\n"
+	if buffer.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buffer.String())
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		root     string
+		expected []Diagnostic
+	}{
+		{"column", "main.go:3:2: undefined: foo\n", "", []Diagnostic{{File: "main.go", Line: 3, Column: 2, Message: "undefined: foo"}}},
+		{"no column", "asm.s:10: unexpected EOF\n", "", []Diagnostic{{File: "asm.s", Line: 10, Message: "unexpected EOF"}}},
+		{"relative", "./cmd/main.go:1:1: expected 'package'\n", "", []Diagnostic{{File: "cmd/main.go", Line: 1, Column: 1, Message: "expected 'package'"}}},
+		{"container", "/source/cmd/main.go:4:5: x declared and not used\n", "/source/", []Diagnostic{{File: "cmd/main.go", Line: 4, Column: 5, Message: "x declared and not used"}}},
+		{
+			"continuation",
+			"# example.com/hello\nmain.go:5:9: cannot use x (variable of type int) as string value:\n\tneed string\n\thave int\nmain.go:6:1: missing return\n",
+			"",
+			[]Diagnostic{
+				{File: "main.go", Line: 5, Column: 9, Message: "cannot use x (variable of type int) as string value:\nneed string\nhave int"},
+				{File: "main.go", Line: 6, Column: 1, Message: "missing return"},
+			},
+		},
+		{"no diagnostics", "\tindented\ngo: downloading example.com/foo v1.0.0\nmain.txt:1:1: not source\n", "", []Diagnostic{}},
+	}
+
+	for _, test := range tests {
+		err := &CommandError{Output: test.output, Root: test.root}
+		if diagnostics := err.Diagnostics(); !reflect.DeepEqual(diagnostics, test.expected) {
+			t.Errorf("%s: Expected %+v but got %+v", test.name, test.expected, diagnostics)
+		}
+	}
+}
+
+func TestAnnotateGitLab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	report, github, gitlab := CodeQualityReport, os.Getenv("GITHUB_ACTIONS"), os.Getenv("GITLAB_CI")
+	defer func() {
+		CodeQualityReport = report
+		os.Setenv("GITHUB_ACTIONS", github)
+		os.Setenv("GITLAB_CI", gitlab)
+		codeQualityIssues, reported = []map[string]interface{}{}, make(map[string]bool)
+	}()
+
+	CodeQualityReport = filepath.Join(dir, "gl-code-quality-report.json")
+	os.Setenv("GITHUB_ACTIONS", "")
+	os.Setenv("GITLAB_CI", "true")
+
+	// the builds for two platforms fail, with one error in common
+	common := Diagnostic{File: "main.go", Line: 3, Column: 2, Message: "undefined: foo"}
+	err = annotate([]Diagnostic{common, {File: "main_linux.go", Line: 1, Column: 1, Message: "undefined: bar"}})
+	if err == nil {
+		err = annotate([]Diagnostic{common, {File: "main_windows.go", Line: 1, Column: 1, Message: "undefined: baz"}})
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadFile(CodeQualityReport)
+	}
+
+	issues := []struct {
+		Location struct{ Path string }
+	}{}
+	if err == nil {
+		err = json.Unmarshal(data, &issues)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	paths := []string{}
+	for _, issue := range issues {
+		paths = append(paths, issue.Location.Path)
+	}
+
+	if expected := []string{"main.go", "main_linux.go", "main_windows.go"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected issues for %v but got %v", expected, paths)
+	}
+}
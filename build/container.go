@@ -0,0 +1,134 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abates/gack"
+	"github.com/abates/gack/command"
+)
+
+// ContainerConfig selects the container runtime and the image used by
+// backends that build inside of a container
+type ContainerConfig struct {
+	Runtime      string `yaml:"runtime" config_name:"runtime" desc:"Container runtime" allowed:"docker,podman,nerdctl"`
+	Image        string `yaml:"image" config_name:"image" desc:"Build image, pin it with a digest such as karalabe/xgo-latest@sha256:<digest>"`
+	ImageArchive string `yaml:"image_archive" config_name:"image_archive" desc:"Tarball the image is loaded from instead of being pulled, for building offline"`
+}
+
+var runtimes = []string{"docker", "podman", "nerdctl"}
+
+func (c ContainerConfig) runtime() (string, error) {
+	if !contains(runtimes, c.Runtime) {
+		return "", fmt.Errorf("Unknown container runtime %q, expected one of %s", c.Runtime, strings.Join(runtimes, ", "))
+	}
+	return c.Runtime, nil
+}
+
+// Pinned indicates whether the image is referred to by digest
+func (c ContainerConfig) Pinned() bool {
+	return strings.Contains(c.Image, "@sha256:")
+}
+
+// hasImage checks whether the image is present in the runtime
+func (c ContainerConfig) hasImage() (bool, error) {
+	runtime, err := c.runtime()
+	if err == nil {
+		_, err = execute(nil, runtime, "image", "inspect", c.Image)
+		if _, ok := err.(*CommandError); ok {
+			return false, nil
+		}
+	}
+	return err == nil, err
+}
+
+// install makes the image available to the runtime, loading it from the
+// image archive when there is one and otherwise pulling it.  Images that
+// are pinned by digest are only pulled when they are missing
+func (c ContainerConfig) install() error {
+	runtime, err := c.runtime()
+	if err != nil {
+		return err
+	}
+
+	if c.ImageArchive != "" {
+		gack.Logf("Loading %s from %s\n", c.Image, c.ImageArchive)
+		_, err = executeCommand(&command.Command{Name: runtime, Args: []string{"load", "-i", c.ImageArchive}, Stream: gack.Stdout})
+		var found bool
+		if err == nil {
+			found, err = c.hasImage()
+		}
+
+		if err == nil && !found {
+			err = fmt.Errorf("%s does not contain %s", c.ImageArchive, c.Image)
+		}
+		return err
+	}
+
+	if c.Pinned() {
+		if found, err := c.hasImage(); err != nil || found {
+			return err
+		}
+	}
+
+	gack.Logf("Pulling %s\n", c.Image)
+	_, err = executeCommand(&command.Command{Name: runtime, Args: []string{"pull", c.Image}, Stream: gack.Stdout})
+	return err
+}
+
+// Checker is implemented by backends that can check whether they are ready
+// to build without installing anything
+type Checker interface {
+	Check(config *Config) error
+}
+
+// doctor checks every backend used to build the project
+func (b *builder) doctor(ctx *gack.Context) error {
+	checks := make(map[string]error)
+	for _, binary := range ctx.Config.Executables() {
+		config, err := GetConfig(ctx.Config, binary)
+		var platforms []Platform
+		if err == nil {
+			platforms, err = config.GetPlatforms()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, platform := range platforms {
+			name := config.backendName(platform.OSName())
+			if _, found := checks[name]; found {
+				continue
+			}
+
+			backend, err := GetBackend(name)
+			if checker, ok := backend.(Checker); ok && err == nil {
+				err = checker.Check(config)
+			}
+			checks[name] = err
+		}
+	}
+
+	names := []string{}
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		if checks[name] == nil {
+			gack.Logf("%s: OK\n", name)
+		} else {
+			gack.Logf("%s: %v\n", name, checks[name])
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d build backends are not ready", failed, len(names))
+	}
+	return nil
+}
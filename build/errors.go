@@ -0,0 +1,166 @@
+package build
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abates/gack"
+	"github.com/abates/gack/command"
+)
+
+// CommandError is returned when a command run for a build fails.  It keeps
+// the error from running the command along with everything it printed
+type CommandError struct {
+	Command string
+
+	// ExitCode is the exit status of the command, or -1 if it didn't run
+	ExitCode int
+	Output   string
+	Err      error
+
+	// Root is where the compiler saw the project, such as the directory
+	// it is mounted at in a container.  It is removed from diagnostics
+	Root string
+}
+
+func newCommandError(name string, args []string, output string, err error) *CommandError {
+	return &CommandError{
+		Command:  formatCommand(nil, name, args...),
+		ExitCode: command.ExitCode(err),
+		Output:   output,
+		Err:      err,
+	}
+}
+
+func (e *CommandError) Error() string {
+	output := strings.TrimRight(e.Output, "\n")
+	if output == "" {
+		return fmt.Sprintf("%s failed: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v\n%s", e.Command, e.Err, output)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostics returns the compiler messages in the command's output
+func (e *CommandError) Diagnostics() []Diagnostic {
+	diagnostics := ParseDiagnostics(e.Output)
+	if e.Root != "" {
+		for i := range diagnostics {
+			diagnostics[i].File = strings.TrimPrefix(diagnostics[i].File, e.Root)
+		}
+	}
+	return diagnostics
+}
+
+// Diagnostic is a message from the go toolchain about a line of code
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+var diagnosticPattern = regexp.MustCompile(`^(\S+\.(?:go|s|c|h|cc|cpp)):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseDiagnostics finds the file:line:col messages in the output of the
+// go toolchain.  Indented lines that follow a message are part of it
+func ParseDiagnostics(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		if matches := diagnosticPattern.FindStringSubmatch(line); matches != nil {
+			d := Diagnostic{File: strings.TrimPrefix(matches[1], "./"), Message: matches[4]}
+			d.Line, _ = strconv.Atoi(matches[2])
+			d.Column, _ = strconv.Atoi(matches[3])
+			diagnostics = append(diagnostics, d)
+		} else if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			diagnostics[len(diagnostics)-1].Message += "\n" + strings.TrimSpace(line)
+		}
+	}
+	return diagnostics
+}
+
+// CodeQualityReport is where diagnostics are written for GitLab, which
+// shows them in merge requests when the file is a codequality report
+var CodeQualityReport = "gl-code-quality-report.json"
+
+var (
+	issuesMu sync.Mutex
+
+	// codeQualityIssues are every diagnostic reported to GitLab so far,
+	// the report is rewritten with all of them after each failed build
+	codeQualityIssues = []map[string]interface{}{}
+	reported          = make(map[string]bool)
+)
+
+// annotate reports diagnostics to the CI system the build is running in
+func annotate(diagnostics []Diagnostic) error {
+	if len(diagnostics) == 0 {
+		return nil
+	}
+
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		for _, d := range diagnostics {
+			fmt.Fprintf(gack.Stdout, "::error file=%s,line=%d,col=%d::%s\n", githubEscapeProperty(d.File), d.Line, d.Column, githubEscape(d.Message))
+		}
+	}
+
+	if os.Getenv("GITLAB_CI") == "true" {
+		issuesMu.Lock()
+		defer issuesMu.Unlock()
+		for _, d := range diagnostics {
+			// the same error is usually reported by the build for every
+			// platform
+			fingerprint := fmt.Sprintf("%x", sha1.Sum([]byte(d.String())))
+			if reported[fingerprint] {
+				continue
+			}
+			reported[fingerprint] = true
+
+			codeQualityIssues = append(codeQualityIssues, map[string]interface{}{
+				"description": d.Message,
+				"check_name":  "go build",
+				"fingerprint": fingerprint,
+				"severity":    "blocker",
+				"location": map[string]interface{}{
+					"path":  d.File,
+					"lines": map[string]int{"begin": d.Line},
+				},
+			})
+		}
+
+		data, err := json.MarshalIndent(codeQualityIssues, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(CodeQualityReport, data, 0644)
+		}
+		return err
+	}
+	return nil
+}
+
+// githubEscape escapes the characters that end a workflow command
+func githubEscape(message string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(message)
+}
+
+// githubEscapeProperty escapes a workflow command property, which also
+// ends at a : or ,
+func githubEscapeProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
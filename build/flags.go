@@ -0,0 +1,113 @@
+package build
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Flags are the go toolchain settings used to build a binary.  They can be
+// set for every build and overridden for platforms and architectures
+type Flags struct {
+	Tags       []string          `yaml:"tags,omitempty" config_name:"tags" desc:"Build tags"`
+	Trimpath   *bool             `yaml:"trimpath,omitempty" config_name:"trimpath" desc:"Remove file system paths from the compiled binaries"`
+	Gcflags    string            `yaml:"gcflags,omitempty" config_name:"gcflags" desc:"Arguments passed to the go compiler"`
+	CgoEnabled *bool             `yaml:"cgo_enabled,omitempty" config_name:"cgo_enabled" desc:"Enable cgo, the native backend disables it unless this is set"`
+	Env        map[string]string `yaml:"env,omitempty" config_name:"env" desc:"Extra environment variables for the build"`
+}
+
+// merge returns a copy of f with every field set in override replacing the
+// field in f.  Environment variables are merged
+func (f Flags) merge(override Flags) Flags {
+	merged := f
+	if override.Tags != nil {
+		merged.Tags = override.Tags
+	}
+
+	if override.Trimpath != nil {
+		merged.Trimpath = override.Trimpath
+	}
+
+	if override.Gcflags != "" {
+		merged.Gcflags = override.Gcflags
+	}
+
+	if override.CgoEnabled != nil {
+		merged.CgoEnabled = override.CgoEnabled
+	}
+
+	merged.Env = make(map[string]string)
+	for _, env := range []map[string]string{f.Env, override.Env} {
+		for name, value := range env {
+			merged.Env[name] = value
+		}
+	}
+	return merged
+}
+
+// Args returns the go build arguments for the flags
+func (f Flags) Args() []string {
+	args := []string{}
+	if len(f.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(f.Tags, ","))
+	}
+
+	if f.Trimpath != nil && *f.Trimpath {
+		args = append(args, "-trimpath")
+	}
+
+	if f.Gcflags != "" {
+		args = append(args, "-gcflags", f.Gcflags)
+	}
+	return args
+}
+
+// Environ returns the environment variables for the flags, sorted by name.
+// cgo is set according to CgoEnabled, or to cgoDefault when it isn't set
+func (f Flags) Environ(cgoDefault bool) []string {
+	cgo := cgoDefault
+	if f.CgoEnabled != nil {
+		cgo = *f.CgoEnabled
+	}
+
+	cgoEnabled := "0"
+	if cgo {
+		cgoEnabled = "1"
+	}
+	return append(f.env(), "CGO_ENABLED="+cgoEnabled)
+}
+
+// env returns the extra environment variables sorted by name
+func (f Flags) env() []string {
+	env := []string{}
+	for name, value := range f.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// GetFlags returns the flags for a platform and architecture.  Overrides for
+// the platform (linux) are applied first, followed by overrides for the
+// platform and architecture (linux/arm-7)
+func (c *Config) GetFlags(platform, arch string) Flags {
+	flags := c.Flags.merge(Flags{})
+	for _, key := range []string{platform, platform + "/" + arch} {
+		if override, found := c.PlatformFlags[key]; found {
+			flags = flags.merge(override)
+		}
+	}
+	return flags
+}
+
+// formatCommand formats a command and its environment for display
+func formatCommand(env []string, name string, args ...string) string {
+	words := []string{}
+	for _, word := range append(append(append([]string(nil), env...), name), args...) {
+		if word == "" || strings.ContainsAny(word, " \t'\"$") {
+			word = strconv.Quote(word)
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
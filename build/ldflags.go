@@ -0,0 +1,88 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/abates/gack"
+)
+
+// TemplateData is available to the templates in ldflags_vars.  The fields of
+// the project config, such as .Version and .PackageName, can be used directly
+type TemplateData struct {
+	*gack.Config
+	Binary       string
+	Platform     string
+	Architecture string
+
+	// Commit is the git commit being built, or empty outside of a git repository
+	Commit string
+
+	// Date is the time of the build in RFC 3339 format
+	Date string
+}
+
+func newTemplateData(config *gack.Config, request *Request) *TemplateData {
+	commit, err := execute(nil, "git", "rev-parse", "HEAD")
+	if err != nil {
+		commit = ""
+	}
+
+	return &TemplateData{
+		Config:       config,
+		Binary:       request.Binary.Name,
+		Platform:     request.Platform.OSName(),
+		Architecture: request.Platform.ArchName(),
+		Commit:       strings.TrimSpace(commit),
+		Date:         request.Date.Format(time.RFC3339),
+	}
+}
+
+// Ldflags renders the ldflags_vars templates into linker flags that set
+// each variable with -X
+func (c *Config) Ldflags(data *TemplateData) (string, error) {
+	names := []string{}
+	for name := range c.LdflagsVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := []string{}
+	for _, name := range names {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(c.LdflagsVars[name])
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse ldflags_vars %s: %v", name, err)
+		}
+
+		var buffer bytes.Buffer
+		if err = tmpl.Execute(&buffer, data); err != nil {
+			return "", fmt.Errorf("Failed to render ldflags_vars %s: %v", name, err)
+		}
+
+		arg, err := quoteLdflag(name + "=" + buffer.String())
+		if err != nil {
+			return "", fmt.Errorf("Failed to render ldflags_vars %s: %v", name, err)
+		}
+		flags = append(flags, "-X "+arg)
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// quoteLdflag quotes arg the way the go command splits -ldflags
+// (cmd/internal/quoted).  Quotes can't be escaped, so arg is quoted with
+// whichever quote it doesn't contain
+func quoteLdflag(arg string) (string, error) {
+	switch {
+	case !strings.ContainsAny(arg, " \t\n\r'\""):
+		return arg, nil
+	case !strings.Contains(arg, "'"):
+		return "'" + arg + "'", nil
+	case !strings.Contains(arg, `"`):
+		return `"` + arg + `"`, nil
+	}
+	return "", fmt.Errorf("%q contains both ' and \" characters, which -ldflags can't quote", arg)
+}
@@ -0,0 +1,198 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// droppedPlatforms are ports that go tool dist list no longer shows, but
+// that older toolchains, like the one in xgo, still build
+var droppedPlatforms = []string{
+	"darwin/386", "darwin/arm", "freebsd/riscv64", "openbsd/mips64", "windows/arm",
+}
+
+// knownPlatforms is used in place of go tool dist list when it can't be run
+var knownPlatforms = map[string]bool{
+	"aix/ppc64": true, "android/386": true, "android/amd64": true, "android/arm": true,
+	"android/arm64": true, "darwin/386": true, "darwin/amd64": true, "darwin/arm": true,
+	"darwin/arm64": true, "dragonfly/amd64": true, "freebsd/386": true, "freebsd/amd64": true,
+	"freebsd/arm": true, "freebsd/arm64": true, "freebsd/riscv64": true, "illumos/amd64": true,
+	"ios/amd64": true, "ios/arm64": true, "js/wasm": true, "linux/386": true,
+	"linux/amd64": true, "linux/arm": true, "linux/arm64": true, "linux/loong64": true,
+	"linux/mips": true, "linux/mips64": true, "linux/mips64le": true, "linux/mipsle": true,
+	"linux/ppc64": true, "linux/ppc64le": true, "linux/riscv64": true, "linux/s390x": true,
+	"netbsd/386": true, "netbsd/amd64": true, "netbsd/arm": true, "netbsd/arm64": true,
+	"openbsd/386": true, "openbsd/amd64": true, "openbsd/arm": true, "openbsd/arm64": true,
+	"openbsd/mips64": true, "openbsd/ppc64": true, "openbsd/riscv64": true, "plan9/386": true,
+	"plan9/amd64": true, "plan9/arm": true, "solaris/amd64": true, "wasip1/wasm": true,
+	"windows/386": true, "windows/amd64": true, "windows/arm": true, "windows/arm64": true,
+}
+
+var (
+	platformsOnce sync.Once
+	platforms     map[string]bool
+)
+
+// validPlatforms returns the GOOS/GOARCH pairs that can be built.  They
+// are read from go tool dist list the first time, falling back to
+// knownPlatforms when the go tool can't be run
+func validPlatforms() map[string]bool {
+	platformsOnce.Do(func() {
+		output, err := execute(nil, "go", "tool", "dist", "list", "-json")
+		if err == nil {
+			platforms, err = parseDistList(output)
+		}
+
+		if err != nil {
+			platforms = knownPlatforms
+		}
+	})
+	return platforms
+}
+
+// parseDistList parses the output of go tool dist list -json, adding the
+// dropped platforms
+func parseDistList(output string) (map[string]bool, error) {
+	list := []struct{ GOOS, GOARCH string }{}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return nil, err
+	} else if len(list) == 0 {
+		return nil, fmt.Errorf("go tool dist list did not list any platforms")
+	}
+
+	platforms := make(map[string]bool)
+	for _, platform := range list {
+		platforms[platform.GOOS+"/"+platform.GOARCH] = true
+	}
+
+	for _, platform := range droppedPlatforms {
+		platforms[platform] = true
+	}
+	return platforms, nil
+}
+
+// variants are the allowed architecture variants and the environment
+// variable that selects them
+var variants = map[string]struct {
+	env    string
+	values []string
+}{
+	"arm":      {"GOARM", []string{"5", "6", "7"}},
+	"amd64":    {"GOAMD64", []string{"v1", "v2", "v3", "v4"}},
+	"mips":     {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mipsle":   {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mips64":   {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+	"mips64le": {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+}
+
+// Platform is an operating system and architecture to build for.  In the
+// config they are written xgo style, with an optional minimum OS version
+// and architecture variant, such as darwin-10.6 and arm-7
+type Platform struct {
+	OS        string
+	OSVersion string
+	Arch      string
+	Variant   string
+}
+
+// ParsePlatform parses and validates a platform and architecture
+func ParsePlatform(platform, arch string) (Platform, error) {
+	p := Platform{}
+	p.OS, p.OSVersion = splitVariant(platform)
+	p.Arch, p.Variant = splitVariant(arch)
+
+	if !validPlatforms()[p.OS+"/"+p.Arch] {
+		return p, fmt.Errorf("Unknown platform %s/%s", p.OS, p.Arch)
+	}
+
+	if p.Variant != "" {
+		variant, found := variants[p.Arch]
+		if !found {
+			return p, fmt.Errorf("Architecture %s does not have variants, but got %s", p.Arch, arch)
+		}
+
+		if !contains(variant.values, p.Variant) {
+			return p, fmt.Errorf("Unknown %s variant %q, expected one of %s", p.Arch, p.Variant, strings.Join(variant.values, ", "))
+		}
+	}
+	return p, nil
+}
+
+func splitVariant(str string) (string, string) {
+	parts := strings.SplitN(str, "-", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// OSName returns the operating system as it is written in the config,
+// including the version
+func (p Platform) OSName() string {
+	if p.OSVersion == "" {
+		return p.OS
+	}
+	return p.OS + "-" + p.OSVersion
+}
+
+// ArchName returns the architecture as it is written in the config,
+// including the variant
+func (p Platform) ArchName() string {
+	if p.Variant == "" {
+		return p.Arch
+	}
+	return p.Arch + "-" + p.Variant
+}
+
+func (p Platform) String() string {
+	return p.OSName() + "/" + p.ArchName()
+}
+
+// Ext returns the file extension of executables for the platform
+func (p Platform) Ext() string {
+	if p.OS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// GoEnv returns the go toolchain environment that builds for the platform
+func (p Platform) GoEnv() []string {
+	env := []string{"GOOS=" + p.OS, "GOARCH=" + p.Arch}
+	if p.Variant != "" {
+		env = append(env, variants[p.Arch].env+"="+p.Variant)
+	}
+	return env
+}
+
+// GetPlatforms returns the platforms listed in the config, sorted by name.  An
+// error is returned for the first platform that is not valid
+func (c *Config) GetPlatforms() ([]Platform, error) {
+	platforms := []Platform{}
+	for platform, archs := range c.Platforms {
+		for _, arch := range archs {
+			p, err := ParsePlatform(platform, arch)
+			if err != nil {
+				return nil, err
+			}
+			platforms = append(platforms, p)
+		}
+	}
+
+	sort.Slice(platforms, func(i, j int) bool {
+		return platforms[i].String() < platforms[j].String()
+	})
+	return platforms, nil
+}
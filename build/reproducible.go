@@ -0,0 +1,189 @@
+package build
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abates/gack"
+)
+
+// nondeterministicEnv are inherited environment variables that change the
+// output of the go toolchain.  They are cleared for reproducible builds,
+// values set in the build config's env are still used
+var nondeterministicEnv = []string{
+	"GOFLAGS",
+	"GOEXPERIMENT",
+	"GOROOT_FINAL",
+	"CGO_CFLAGS",
+	"CGO_CPPFLAGS",
+	"CGO_CXXFLAGS",
+	"CGO_FFLAGS",
+	"CGO_LDFLAGS",
+}
+
+// buildDate returns the time a build is stamped with.  SOURCE_DATE_EPOCH is
+// always honoured, reproducible builds otherwise use the time of the last
+// commit so that rebuilding the same commit gives the same date
+func buildDate(reproducible bool) time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+
+	if reproducible {
+		output, err := execute(nil, "git", "log", "-1", "--format=%ct")
+		epoch, perr := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+		if err != nil || perr != nil {
+			epoch = 0
+		}
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+// makeReproducible changes a request so the backend builds the same bytes
+// every time it is run on the same commit
+func (r *Request) makeReproducible() {
+	trimpath := true
+	r.Reproducible = true
+	r.Flags.Trimpath = &trimpath
+	r.Ldflags = strings.TrimSpace(r.Ldflags + " -buildid=")
+}
+
+// Environ returns the environment the backend should add for the request.
+// Reproducible builds clear the nondeterministic variables and set
+// SOURCE_DATE_EPOCH, which cgo compilers also honour
+func (r *Request) Environ() []string {
+	env := []string{}
+	if r.Reproducible {
+		for _, name := range nondeterministicEnv {
+			env = append(env, name+"=")
+		}
+		env = append(env, fmt.Sprintf("SOURCE_DATE_EPOCH=%d", r.Date.Unix()))
+	}
+	return env
+}
+
+// hashArtifacts returns the sha256 of every file below dir keyed by its
+// path relative to dir
+func hashArtifacts(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		if _, err = io.Copy(hash, file); err == nil {
+			var name string
+			name, err = filepath.Rel(dir, path)
+			hashes[name] = fmt.Sprintf("%x", hash.Sum(nil))
+		}
+		return err
+	})
+	return hashes, err
+}
+
+// hashRecorded adds the artifacts recorded in manifestFile to hashes.
+// Artifacts below dir are keyed by their path relative to dir, the same as
+// hashArtifacts, and the others by their path
+func hashRecorded(hashes map[string]string, dir, manifestFile string) error {
+	manifest, err := gack.ReadManifest(manifestFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	for _, artifact := range manifest.Artifacts {
+		name := artifact.Path
+		if rel, err := filepath.Rel(dir, artifact.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		hashes[name] = artifact.SHA256
+	}
+	return err
+}
+
+// verifyReproducible builds a target twice, each time into its own
+// directory, and compares every artifact that was built.  Each build
+// records its artifacts in its own manifest, so that artifacts built
+// outside of the output directory, such as debs, are compared as well
+func verifyReproducible(mux *gack.Mux) gack.ExecuteFunc {
+	return func(ctx *gack.Context) error {
+		target := ctx.Param("target")
+		outputDir, manifestFile := OutputDir, gack.ManifestFile
+		defer func() { OutputDir, gack.ManifestFile = outputDir, manifestFile }()
+
+		builds := []map[string]string{}
+		for i := 1; i <= 2; i++ {
+			OutputDir = filepath.Join(outputDir, "reproducible", strconv.Itoa(i))
+			gack.ManifestFile = OutputDir + ".json"
+			gack.Logf("Building %s into %s\n", target, OutputDir)
+			err := os.RemoveAll(OutputDir)
+			if err == nil {
+				err = os.RemoveAll(gack.ManifestFile)
+			}
+
+			if err == nil {
+				err = mux.Execute(target)
+			}
+
+			var hashes map[string]string
+			if err == nil {
+				hashes, err = hashArtifacts(OutputDir)
+			}
+
+			if err == nil {
+				err = hashRecorded(hashes, OutputDir, gack.ManifestFile)
+			}
+
+			if err != nil {
+				return err
+			} else if len(hashes) == 0 {
+				return fmt.Errorf("%s did not build anything", target)
+			}
+			builds = append(builds, hashes)
+		}
+
+		names := []string{}
+		for name := range builds[0] {
+			names = append(names, name)
+		}
+
+		for name := range builds[1] {
+			if _, found := builds[0][name]; !found {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		differ := 0
+		for _, name := range names {
+			first, second := builds[0][name], builds[1][name]
+			if first == "" || second == "" {
+				gack.Logf("%s was only built once\n", name)
+				differ++
+			} else if first != second {
+				gack.Logf("%s differs: %s != %s\n", name, first, second)
+				differ++
+			}
+		}
+
+		if differ > 0 {
+			return fmt.Errorf("%d of %d artifacts are not reproducible", differ, len(names))
+		}
+		gack.Logf("All %d artifacts are reproducible\n", len(names))
+		return nil
+	}
+}
@@ -0,0 +1,217 @@
+package build
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abates/gack"
+)
+
+// machoCPUs are the Mach-O cpu types for the architectures that can be
+// combined into a universal binary
+var machoCPUs = map[string]macho.Cpu{
+	"386":   macho.Cpu386,
+	"amd64": macho.CpuAmd64,
+	"arm":   macho.CpuArm,
+	"arm64": macho.CpuArm64,
+}
+
+// universal is the platform of universal darwin binaries
+var universal = Platform{OS: "darwin", Arch: "universal"}
+
+// darwinPlatforms returns the darwin platforms that are built
+func (c *Config) darwinPlatforms() ([]Platform, error) {
+	platforms, err := c.GetPlatforms()
+	darwin := []Platform{}
+	for _, platform := range platforms {
+		if platform.OS == "darwin" {
+			darwin = append(darwin, platform)
+		}
+	}
+	return darwin, err
+}
+
+// universalDependencies returns the build targets of the darwin binaries
+// that are combined into a universal binary
+func universalDependencies(config *gack.Config, binary gack.Binary, name string) ([]string, error) {
+	buildConfig, err := GetConfig(config, binary)
+	var platforms []Platform
+	if err == nil {
+		platforms, err = buildConfig.darwinPlatforms()
+	}
+
+	dependencies := []string{}
+	for _, platform := range platforms {
+		dependencies = append(dependencies, fmt.Sprintf("build/%s", Name(name, platform.OSName(), platform.ArchName())))
+	}
+	return dependencies, err
+}
+
+// universalBuilder combines the darwin builds of a binary into a
+// universal binary
+type universalBuilder struct {
+	*builder
+
+	// binary is nil for the generic target
+	binary *gack.Binary
+}
+
+func (u *universalBuilder) Execute(ctx *gack.Context) error {
+	return u.combine(ctx, false)
+}
+
+func (u *universalBuilder) Plan(ctx *gack.Context) error {
+	return u.combine(ctx, true)
+}
+
+func (u *universalBuilder) combine(ctx *gack.Context, dryRun bool) error {
+	binary := lookupBinary(ctx.Config, ctx.Param("package"))
+	if u.binary != nil {
+		binary = *u.binary
+	}
+
+	gack.Logf("Building %s\n", ctx.Target.Subject())
+	config, err := GetConfig(ctx.Config, binary)
+	var platforms []Platform
+	if err == nil {
+		platforms, err = config.darwinPlatforms()
+	}
+
+	if err == nil && len(platforms) == 0 {
+		err = fmt.Errorf("No darwin platforms are built for %s", binary.Name)
+	}
+
+	inputs := make(map[Platform]string)
+	for _, platform := range platforms {
+		if err == nil {
+			inputs[platform], err = ArtifactPath(ctx.Config, binary, platform)
+		}
+	}
+
+	var output string
+	if err == nil {
+		output, err = ArtifactPath(ctx.Config, binary, universal)
+	}
+
+	if err != nil {
+		return err
+	} else if dryRun {
+		paths := []string{}
+		for _, platform := range platforms {
+			paths = append(paths, inputs[platform])
+		}
+		gack.Logf("\tcombine %s into %s\n", strings.Join(paths, " "), output)
+		return nil
+	}
+
+	if err = writeUniversal(output, inputs); err == nil {
+		err = gack.RecordArtifact(gack.Artifact{
+			Path:    output,
+			Kind:    gack.ArtifactBinary,
+			OS:      universal.OS,
+			Arch:    universal.Arch,
+			Version: ctx.Config.Version,
+			Target:  ctx.Target.Subject(),
+			// every slice is built by the same toolchain
+			GoVersion: GoVersion(inputs[platforms[0]]),
+		})
+	}
+	return err
+}
+
+// fatSlice is one of the binaries in a universal binary
+type fatSlice struct {
+	header macho.FatArchHeader
+	data   []byte
+}
+
+// readSlice reads and validates a darwin binary built for platform
+func readSlice(platform Platform, filename string) (*fatSlice, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		if _, ferr := macho.NewFatFile(bytes.NewReader(data)); ferr == nil {
+			return nil, fmt.Errorf("%s is already a universal binary", filename)
+		}
+		return nil, fmt.Errorf("%s is not a Mach-O file: %v", filename, err)
+	}
+
+	if file.Type != macho.TypeExec {
+		return nil, fmt.Errorf("%s is a %v, not an executable", filename, file.Type)
+	}
+
+	if cpu, found := machoCPUs[platform.Arch]; !found {
+		return nil, fmt.Errorf("%s cannot be part of a universal binary", platform)
+	} else if file.Cpu != cpu {
+		return nil, fmt.Errorf("%s was built for %v, expected %v", filename, file.Cpu, cpu)
+	}
+
+	// slices are page aligned, and arm64 pages are 16K
+	align := uint32(12)
+	if file.Cpu == macho.CpuArm64 {
+		align = 14
+	}
+
+	return &fatSlice{
+		header: macho.FatArchHeader{Cpu: file.Cpu, SubCpu: file.SubCpu, Size: uint32(len(data)), Align: align},
+		data:   data,
+	}, nil
+}
+
+// writeUniversal combines darwin binaries into a universal (fat) binary
+func writeUniversal(output string, inputs map[Platform]string) error {
+	slices := []*fatSlice{}
+	for platform, filename := range inputs {
+		slice, err := readSlice(platform, filename)
+		if err != nil {
+			return err
+		}
+		slices = append(slices, slice)
+	}
+
+	sort.Slice(slices, func(i, j int) bool { return slices[i].header.Cpu < slices[j].header.Cpu })
+	for i := 1; i < len(slices); i++ {
+		if slices[i].header.Cpu == slices[i-1].header.Cpu {
+			return fmt.Errorf("More than one binary was built for %v", slices[i].header.Cpu)
+		}
+	}
+
+	var buffer bytes.Buffer
+	offset := uint32(8 + 20*len(slices))
+	binary.Write(&buffer, binary.BigEndian, []uint32{macho.MagicFat, uint32(len(slices))})
+	for _, slice := range slices {
+		alignment := uint32(1) << slice.header.Align
+		offset = (offset + alignment - 1) &^ (alignment - 1)
+		slice.header.Offset = offset
+		binary.Write(&buffer, binary.BigEndian, slice.header)
+		offset += slice.header.Size
+	}
+
+	for _, slice := range slices {
+		buffer.Write(make([]byte, int(slice.header.Offset)-buffer.Len()))
+		buffer.Write(slice.data)
+	}
+
+	// make sure the result can be read back
+	fat, err := macho.NewFatFile(bytes.NewReader(buffer.Bytes()))
+	if err == nil {
+		fat.Close()
+		err = os.MkdirAll(filepath.Dir(output), 0755)
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(output, buffer.Bytes(), 0755)
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package checksums
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abates/gack"
+)
+
+var strict = flag.Bool("strict", false, "fail verify when a file listed in the checksums is missing")
+
+type Config struct {
+	Algorithms []string `yaml:"algorithms" config_name:"algorithms" desc:"Checksum files to write" allowed:"sha256,sha512"`
+	Signing    string   `yaml:"signing" config_name:"signing" desc:"How the checksum files are signed" allowed:"none,openpgp,minisign"`
+	SigningKey string   `yaml:"signing_key" config_name:"signing_key" desc:"Armored OpenPGP private key or minisign secret key, usually a secret:// reference"`
+	Passphrase string   `yaml:"passphrase" config_name:"passphrase" desc:"Passphrase of the signing key, usually a secret:// reference"`
+	PublicKey  string   `yaml:"public_key" config_name:"public_key" desc:"Armored OpenPGP public key or minisign public key used by verify, secret://file/<path> reads it from a file"`
+}
+
+func DefaultConfig() (string, *Config) {
+	return "checksums", &Config{
+		Algorithms: []string{"sha256", "sha512"},
+		Signing:    "none",
+	}
+}
+
+// GetConfig returns the checksums section of the config
+func GetConfig(config *gack.Config) (*Config, error) {
+	name, checksumsConfig := DefaultConfig()
+	// configured algorithms replace the defaults instead of being decoded
+	// over them
+	algorithms := checksumsConfig.Algorithms
+	checksumsConfig.Algorithms = nil
+	err := config.Get(name, checksumsConfig)
+	if err == gack.ErrConfigKeyNotFound {
+		err = nil
+	}
+
+	if len(checksumsConfig.Algorithms) == 0 {
+		checksumsConfig.Algorithms = algorithms
+	}
+	return checksumsConfig, err
+}
+
+// algorithms are the supported checksums and the files they are written to
+var algorithms = map[string]struct {
+	filename string
+	new      func() hash.Hash
+}{
+	"sha256": {"SHA256SUMS", sha256.New},
+	"sha512": {"SHA512SUMS", sha512.New},
+}
+
+// Filename returns the name of the checksum file for algorithm
+func Filename(algorithm string) (string, error) {
+	if a, found := algorithms[algorithm]; found {
+		return a.filename, nil
+	}
+	return "", fmt.Errorf("Unknown checksum algorithm %q, expected sha256 or sha512", algorithm)
+}
+
+// Dir is the directory that checksum files are written to
+func Dir() string {
+	return filepath.Dir(gack.ManifestFile)
+}
+
+func hashFile(algorithm, filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := algorithms[algorithm].new()
+	_, err = io.Copy(h, file)
+	return fmt.Sprintf("%x", h.Sum(nil)), err
+}
+
+// artifacts returns the artifacts in the manifest keyed by file name.
+// Checksum files list file names only, as the files are usually published
+// together in one place
+func artifacts() (map[string]string, error) {
+	manifest, err := gack.ReadManifest(gack.ManifestFile)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string)
+	for _, artifact := range manifest.Artifacts {
+		name := filepath.Base(artifact.Path)
+		if path, found := paths[name]; found {
+			return nil, fmt.Errorf("%s and %s have the same file name", path, artifact.Path)
+		}
+		paths[name] = artifact.Path
+	}
+	return paths, nil
+}
+
+// Write writes a checksum file for each algorithm in the config, listing
+// every artifact in the manifest, and signs it
+func Write(config *Config) error {
+	paths, err := artifacts()
+	if err != nil {
+		return err
+	} else if len(paths) == 0 {
+		return fmt.Errorf("No artifacts are listed in %s", gack.ManifestFile)
+	}
+
+	names := []string{}
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return err
+	}
+
+	for _, algorithm := range config.Algorithms {
+		filename, err := Filename(algorithm)
+		if err != nil {
+			return err
+		}
+
+		var sums strings.Builder
+		for _, name := range names {
+			sum, err := hashFile(algorithm, paths[name])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+		}
+
+		filename = filepath.Join(Dir(), filename)
+		gack.Logf("Writing %s\n", filename)
+		if err = ioutil.WriteFile(filename, []byte(sums.String()), 0644); err == nil {
+			err = sign(config, filename)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks the signatures of the checksum files in dir and then the
+// files they list.  Files are looked for in dir and then at the path in the
+// manifest.  Files that can't be found are skipped, unless strict is set
+func Verify(config *Config, dir string, strict bool) error {
+	paths, err := artifacts()
+	if err != nil {
+		return err
+	}
+
+	checked, failed, missing := 0, 0, 0
+	for _, algorithm := range []string{"sha256", "sha512"} {
+		filename := filepath.Join(dir, algorithms[algorithm].filename)
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err == nil {
+			err = verifySignature(config, filename, data)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				return fmt.Errorf("Malformed line in %s: %q", filename, scanner.Text())
+			}
+
+			// sha256sum marks files that were read in binary mode with a *
+			sum, name := fields[0], strings.TrimPrefix(fields[1], "*")
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err != nil {
+				if path = paths[name]; path == "" {
+					gack.Logf("%s: %s MISSING\n", name, algorithm)
+					missing++
+					continue
+				}
+			}
+
+			actual, err := hashFile(algorithm, path)
+			if err != nil {
+				return err
+			}
+
+			checked++
+			if actual == sum {
+				gack.Logf("%s: %s OK\n", path, algorithm)
+			} else {
+				gack.Logf("%s: %s FAILED\n", path, algorithm)
+				failed++
+			}
+		}
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("No files listed in the checksums in %s were found", dir)
+	} else if failed > 0 {
+		return fmt.Errorf("%d of %d checksums did not match", failed, checked)
+	} else if strict && missing > 0 {
+		return fmt.Errorf("%d of %d listed files are missing", missing, checked+missing)
+	}
+	return nil
+}
+
+type checksums struct{}
+
+func (c *checksums) DefaultConfig() (string, interface{}) {
+	return DefaultConfig()
+}
+
+func (c *checksums) Execute(ctx *gack.Context) error {
+	config, err := GetConfig(ctx.Config)
+	if err == nil {
+		err = Write(config)
+	}
+	return err
+}
+
+func verify(ctx *gack.Context) error {
+	dir := ctx.Param("dir")
+	if dir == "" {
+		dir = Dir()
+	}
+
+	config, err := GetConfig(ctx.Config)
+	if err == nil {
+		err = Verify(config, dir, *strict)
+	}
+	return err
+}
+
+func Register(mux *gack.Mux) {
+	mux.Register("checksums", &checksums{}, "build", "pkg/deb")
+	mux.Register("verify[/:dir]", gack.ExecuteFunc(verify))
+}
@@ -0,0 +1,364 @@
+package checksums
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/abates/gack"
+	"github.com/abates/gack/build"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestRouting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gack.yml")
+	err = ioutil.WriteFile(filename, []byte("package_name: hello\nversion: 1.0.0\nbinaries:\n  - name: hello\n"), 0644)
+
+	var mux *gack.Mux
+	if err == nil {
+		mux, err = gack.NewMuxFromFile(filename)
+	}
+
+	if err == nil {
+		err = build.Register(mux)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	Register(mux)
+
+	tests := []struct {
+		subject string
+		verify  bool
+		param   string
+		value   string
+	}{
+		{"verify-reproducible/build", false, "target", "build"},
+		{"verify-reproducible/pkg/deb", false, "target", "pkg/deb"},
+		{"verify", true, "dir", ""},
+		{"verify/dist", true, "dir", "dist"},
+	}
+
+	for _, test := range tests {
+		target, _, match := mux.Lookup(test.subject)
+		if target == nil {
+			t.Errorf("%s: Expected a target to match", test.subject)
+			continue
+		}
+
+		isVerify := reflect.ValueOf(target.Executable).Pointer() == reflect.ValueOf(verify).Pointer()
+		if isVerify != test.verify {
+			t.Errorf("%s: Expected the verify target to match: %v", test.subject, test.verify)
+		}
+
+		if value, found := match.LookupParam(test.param); value != test.value || (test.value != "" && !found) {
+			t.Errorf("%s: Expected %s to be %q but got %q", test.subject, test.param, test.value, value)
+		}
+	}
+}
+
+// newMinisignKey encodes a new minisign secret key encrypted with
+// passphrase, using small scrypt limits to keep the tests fast, and
+// returns it with its public key
+func newMinisignKey(t *testing.T, passphrase string) (string, string) {
+	public, secret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	keyID, salt := make([]byte, 8), make([]byte, 32)
+	rand.Read(keyID)
+	rand.Read(salt)
+	checksum := blake2b.Sum256(append(append([]byte("Ed"), keyID...), secret...))
+	keynum := append(append(append([]byte(nil), keyID...), secret...), checksum[:]...)
+
+	limits := make([]byte, 16)
+	binary.LittleEndian.PutUint64(limits[:8], 32768)
+	binary.LittleEndian.PutUint64(limits[8:], 16<<20)
+	n, r, p := scryptParams(32768, 16<<20)
+	stream, err := scrypt.Key([]byte(passphrase), salt, n, r, p, len(keynum))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := range keynum {
+		keynum[i] ^= stream[i]
+	}
+
+	key := append(append(append([]byte("EdScB2"), salt...), limits...), keynum...)
+	return "untrusted comment: minisign encrypted secret key\n" + base64.StdEncoding.EncodeToString(key) + "\n",
+		"untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...)) + "\n"
+}
+
+// newOpenPGPKey returns a new armored OpenPGP private key, encrypted with
+// passphrase, and its armored public key
+func newOpenPGPKey(t *testing.T, passphrase string) (string, string) {
+	entity, err := openpgp.NewEntity("gack", "", "gack@example.com", nil)
+	var private, public bytes.Buffer
+	if err == nil {
+		err = entity.EncryptPrivateKeys([]byte(passphrase), nil)
+	}
+
+	if err == nil {
+		err = armorKey(&private, openpgp.PrivateKeyType, func(w io.Writer) error { return entity.SerializePrivateWithoutSigning(w, nil) })
+	}
+
+	if err == nil {
+		err = armorKey(&public, openpgp.PublicKeyType, entity.Serialize)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return private.String(), public.String()
+}
+
+func armorKey(w io.Writer, blockType string, serialize func(io.Writer) error) error {
+	writer, err := armor.Encode(w, blockType, nil)
+	if err == nil {
+		err = serialize(writer)
+	}
+
+	if err == nil {
+		err = writer.Close()
+	}
+	return err
+}
+
+func readTestdata(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return string(data)
+}
+
+// TestMinisignVector checks the minisign implementation against a key and
+// signature made by minisign itself
+func TestMinisignVector(t *testing.T) {
+	public := readTestdata(t, "minisign.pub")
+	message := readTestdata(t, "message.txt")
+	signature := readTestdata(t, "message.txt.minisig")
+
+	if err := minisignVerify(public, []byte(message), []byte(signature)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := minisignVerify(public, []byte(message+"!"), []byte(signature)); err == nil {
+		t.Errorf("Expected an error for a modified message")
+	}
+
+	keyID, secret, err := minisignSecretKey(readTestdata(t, "minisign.key"), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lines, _, _ := minisignLines(public)
+	expected := append(append([]byte("Ed"), keyID...), secret.Public().(ed25519.PublicKey)...)
+	if !bytes.Equal(lines[0], expected) {
+		t.Errorf("Expected the secret key to decode to public key %x but got %x", lines[0], expected)
+	}
+
+	if _, _, err := minisignSecretKey(readTestdata(t, "minisign.key"), "wrong"); err == nil {
+		t.Errorf("Expected an error for the wrong passphrase")
+	}
+}
+
+func TestScryptParams(t *testing.T) {
+	tests := []struct {
+		opsLimit, memLimit uint64
+		n, r, p            int
+	}{
+		// minisign's defaults
+		{33554432, 1073741824, 1 << 20, 8, 1},
+		{32768, 16 << 20, 1 << 10, 8, 1},
+		{0, 0, 2, 8, 512},
+	}
+
+	for _, test := range tests {
+		n, r, p := scryptParams(test.opsLimit, test.memLimit)
+		if n != test.n || r != test.r || p != test.p {
+			t.Errorf("%d/%d: Expected %d, %d, %d but got %d, %d, %d", test.opsLimit, test.memLimit, test.n, test.r, test.p, n, r, p)
+		}
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	minisignKey, minisignPublic := newMinisignKey(t, "secret")
+	openpgpKey, openpgpPublic := newOpenPGPKey(t, "secret")
+
+	tests := []struct {
+		signing string
+		key     string
+		public  string
+		// the public key of another key pair
+		other string
+	}{
+		{"minisign", minisignKey, minisignPublic, readTestdata(t, "minisign.pub")},
+		{"openpgp", openpgpKey, openpgpPublic, ""},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, "SHA256SUMS")
+		data := []byte("0123  hello\n")
+		config := &Config{Signing: test.signing, SigningKey: test.key, Passphrase: "secret", PublicKey: test.public}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err == nil {
+			err = sign(config, filename)
+		}
+
+		if err == nil {
+			err = verifySignature(config, filename, data)
+		}
+
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", test.signing, err)
+			continue
+		}
+
+		if err := verifySignature(config, filename, []byte("4567  hello\n")); err == nil {
+			t.Errorf("%s: Expected an error for modified checksums", test.signing)
+		}
+
+		if test.other != "" {
+			config.PublicKey = test.other
+			if err := verifySignature(config, filename, data); err == nil {
+				t.Errorf("%s: Expected an error for a different public key", test.signing)
+			}
+		}
+
+		config.Passphrase = "wrong"
+		if err := sign(config, filename); err == nil {
+			t.Errorf("%s: Expected an error for the wrong passphrase", test.signing)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifestFile := gack.ManifestFile
+	defer func() { gack.ManifestFile = manifestFile }()
+	gack.ManifestFile = filepath.Join(dir, "manifest.json")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("hello")))
+	tests := []struct {
+		name     string
+		sums     string
+		strict   bool
+		expected string
+	}{
+		{"ok", sum + "  hello\n", false, ""},
+		{"binary mode", sum + " *hello\n", false, ""},
+		{"missing", sum + "  hello\n" + sum + "  missing\n", false, ""},
+		{"strict missing", sum + "  hello\n" + sum + "  missing\n", true, "1 of 2 listed files are missing"},
+		{"mismatch", strings.Repeat("0", 64) + "  hello\n", false, "1 of 1 checksums did not match"},
+		{"nothing found", sum + "  missing\n", false, "No files listed in the checksums in " + dir + " were found"},
+		{"malformed", sum + "\n", false, "Malformed line in " + filepath.Join(dir, "SHA256SUMS") + ": \"" + sum + "\""},
+	}
+
+	for _, test := range tests {
+		err := ioutil.WriteFile(filepath.Join(dir, "SHA256SUMS"), []byte(test.sums), 0644)
+		if err == nil {
+			err = Verify(&Config{Signing: "none"}, dir, test.strict)
+		}
+
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: Unexpected error: %v", test.name, err)
+		} else if test.expected != "" && (err == nil || err.Error() != test.expected) {
+			t.Errorf("%s: Expected error %q but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestWriteVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifestFile := gack.ManifestFile
+	defer func() { gack.ManifestFile = manifestFile }()
+	gack.ManifestFile = filepath.Join(dir, "dist", "manifest.json")
+
+	key, public := newMinisignKey(t, "")
+	config := &Config{Algorithms: []string{"sha256", "sha512"}, Signing: "minisign", SigningKey: key, PublicKey: public}
+	if err := Write(config); err == nil {
+		t.Errorf("Expected an error when no artifacts are recorded")
+	}
+
+	// the artifacts are found at their recorded paths
+	for _, name := range []string{"hello_linux_amd64", "hello_1.0.0_amd64.deb"} {
+		filename := filepath.Join(dir, "build", name)
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte(name), 0644)
+		}
+
+		if err == nil {
+			err = gack.RecordArtifact(gack.Artifact{Path: filename, Kind: gack.ArtifactBinary})
+		}
+
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err = Write(config); err == nil {
+		err = Verify(config, Dir(), true)
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"SHA256SUMS", "SHA256SUMS.minisig", "SHA512SUMS", "SHA512SUMS.minisig"} {
+		if _, err := os.Stat(filepath.Join(Dir(), name)); err != nil {
+			t.Errorf("Expected %s to be written: %v", name, err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "build", "hello_linux_amd64"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := Verify(config, Dir(), true); err == nil || err.Error() != "2 of 4 checksums did not match" {
+		t.Errorf("Expected 2 checksums to not match but got %v", err)
+	}
+}
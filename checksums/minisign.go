@@ -0,0 +1,162 @@
+package checksums
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// minisign keys and signatures are base64 lines following an untrusted
+// comment line.  See https://jedisct1.github.io/minisign/ for the formats
+
+var errMinisignFormat = errors.New("Not a minisign key or signature")
+
+// minisignLines decodes the base64 lines of a minisign file, skipping the
+// comments
+func minisignLines(data string) ([][]byte, []string, error) {
+	lines, comments := [][]byte{}, []string{}
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		} else if strings.HasPrefix(line, "trusted comment: ") {
+			comments = append(comments, strings.TrimPrefix(line, "trusted comment: "))
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, nil, errMinisignFormat
+		}
+		lines = append(lines, decoded)
+	}
+	return lines, comments, nil
+}
+
+// scryptParams picks the scrypt parameters from the libsodium ops and
+// memory limits stored in minisign secret keys
+func scryptParams(opsLimit, memLimit uint64) (n, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+
+	r = 8
+	var nLog2 uint
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / uint64(r*4)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+	} else {
+		maxN := memLimit / uint64(r*128)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+
+		maxRP := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = int(maxRP) / r
+	}
+	return 1 << nLog2, r, p
+}
+
+// minisignSecretKey decodes a minisign secret key, decrypting it with
+// passphrase when it is encrypted, and returns the key id and private key
+func minisignSecretKey(data, passphrase string) ([]byte, ed25519.PrivateKey, error) {
+	lines, _, err := minisignLines(data)
+	if err != nil || len(lines) != 1 || len(lines[0]) != 158 || string(lines[0][:2]) != "Ed" {
+		return nil, nil, errMinisignFormat
+	}
+
+	key := lines[0]
+	kdf, salt := string(key[2:4]), key[6:38]
+	keynum := append([]byte(nil), key[54:158]...)
+	if kdf == "Sc" {
+		n, r, p := scryptParams(binary.LittleEndian.Uint64(key[38:46]), binary.LittleEndian.Uint64(key[46:54]))
+		stream, err := scrypt.Key([]byte(passphrase), salt, n, r, p, len(keynum))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	} else if kdf != "\x00\x00" {
+		return nil, nil, fmt.Errorf("Unknown minisign key derivation %q", kdf)
+	}
+
+	keyID, secret, checksum := keynum[:8], keynum[8:72], keynum[72:104]
+	expected := blake2b.Sum256(append(append([]byte("Ed"), keyID...), secret...))
+	if !bytes.Equal(checksum, expected[:]) {
+		return nil, nil, fmt.Errorf("Wrong passphrase for the minisign secret key")
+	}
+	return keyID, ed25519.PrivateKey(secret), nil
+}
+
+// minisign signs the prehashed data, as minisign -H does
+func minisign(key, passphrase string, data []byte, filename string) ([]byte, error) {
+	keyID, secret, err := minisignSecretKey(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := blake2b.Sum512(data)
+	signature := append(append([]byte("ED"), keyID...), ed25519.Sign(secret, hash[:])...)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(filename))
+	global := ed25519.Sign(secret, append(append([]byte(nil), signature[10:]...), trusted...))
+
+	return []byte(fmt.Sprintf("untrusted comment: signature from gack secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(signature), trusted, base64.StdEncoding.EncodeToString(global))), nil
+}
+
+func minisignVerify(key string, data, signature []byte) error {
+	lines, _, err := minisignLines(key)
+	if err != nil || len(lines) != 1 || len(lines[0]) != 42 || string(lines[0][:2]) != "Ed" {
+		return errMinisignFormat
+	}
+	keyID, public := lines[0][2:10], ed25519.PublicKey(lines[0][10:42])
+
+	lines, comments, err := minisignLines(string(signature))
+	if err != nil || len(lines) != 2 || len(comments) != 1 || len(lines[0]) != 74 {
+		return errMinisignFormat
+	}
+
+	sig := lines[0]
+	if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("Signed with key %X, not %X", sig[2:10], keyID)
+	}
+
+	switch string(sig[:2]) {
+	case "ED":
+		hash := blake2b.Sum512(data)
+		data = hash[:]
+	case "Ed":
+	default:
+		return fmt.Errorf("Unknown minisign signature algorithm %q", sig[:2])
+	}
+
+	if !ed25519.Verify(public, data, sig[10:]) {
+		return errors.New("Signature does not match")
+	}
+
+	if !ed25519.Verify(public, append(append([]byte(nil), sig[10:]...), comments[0]...), lines[1]) {
+		return errors.New("Trusted comment signature does not match")
+	}
+	return nil
+}
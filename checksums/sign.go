@@ -0,0 +1,133 @@
+package checksums
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/abates/gack"
+)
+
+// signatureExt returns the extension of the detached signatures made with
+// a signing method
+func signatureExt(signing string) (string, error) {
+	switch signing {
+	case "openpgp":
+		return ".asc", nil
+	case "minisign":
+		return ".minisig", nil
+	case "", "none":
+		return "", nil
+	}
+	return "", fmt.Errorf("Unknown signing method %q, expected none, openpgp or minisign", signing)
+}
+
+// sign writes a detached signature for filename
+func sign(config *Config, filename string) error {
+	ext, err := signatureExt(config.Signing)
+	if err != nil || ext == "" {
+		return err
+	}
+
+	key, err := gack.ResolveSecret(config.SigningKey)
+	var passphrase string
+	if err == nil {
+		passphrase, err = gack.ResolveSecret(config.Passphrase)
+	}
+
+	var data, signature []byte
+	if err == nil {
+		data, err = ioutil.ReadFile(filename)
+	}
+
+	if err == nil && key == "" {
+		err = fmt.Errorf("A signing_key is needed to sign with %s", config.Signing)
+	}
+
+	if err == nil {
+		if config.Signing == "openpgp" {
+			signature, err = openpgpSign(key, passphrase, data)
+		} else {
+			signature, err = minisign(key, passphrase, data, filename)
+		}
+	}
+
+	if err == nil {
+		gack.Logf("Signing %s\n", filename)
+		err = ioutil.WriteFile(filename+ext, signature, 0644)
+	}
+	return err
+}
+
+// verifySignature checks the detached signature of filename, when the
+// config says the checksums are signed
+func verifySignature(config *Config, filename string, data []byte) error {
+	ext, err := signatureExt(config.Signing)
+	if err != nil || ext == "" {
+		return err
+	}
+
+	var key string
+	var signature []byte
+	key, err = gack.ResolveSecret(config.PublicKey)
+	if err == nil && key == "" {
+		err = fmt.Errorf("A public_key is needed to verify %s signatures", config.Signing)
+	}
+
+	if err == nil {
+		signature, err = ioutil.ReadFile(filename + ext)
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%s is not signed, %s%s is missing", filename, filename, ext)
+		}
+	}
+
+	if err == nil {
+		if config.Signing == "openpgp" {
+			err = openpgpVerify(key, data, signature)
+		} else {
+			err = minisignVerify(key, data, signature)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Bad signature for %s: %v", filename, err)
+		}
+	}
+
+	if err == nil {
+		gack.Logf("%s: signature OK\n", filename)
+	}
+	return err
+}
+
+func openpgpSign(key, passphrase string, data []byte) ([]byte, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil {
+		return nil, err
+	}
+
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("The signing_key is not a private key")
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if err = entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, err
+		}
+	}
+
+	var signature bytes.Buffer
+	err = openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(data), nil)
+	return signature.Bytes(), err
+}
+
+func openpgpVerify(key string, data, signature []byte) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err == nil {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	}
+	return err
+}
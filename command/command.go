@@ -0,0 +1,150 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Command is a program to run
+type Command struct {
+	Name string
+	Args []string
+
+	// Env is added to the environment of the current process
+	Env []string
+
+	// Dir is the working directory, the current directory when empty
+	Dir   string
+	Stdin io.Reader
+
+	// Stream receives the output of the command while it runs.  The output
+	// is returned by Run either way
+	Stream io.Writer
+
+	// Stderr, when set, receives the standard error of the command instead
+	// of it being part of the output
+	Stderr io.Writer
+}
+
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs commands and returns their output, stdout combined with
+// stderr unless the command's Stderr is set.  An error with an ExitCode()
+// int method is returned when the command exits with a non-zero status.
+// Every external program used by gack is run by a Runner so that the
+// commands can be recorded and replayed in tests
+type Runner interface {
+	Run(cmd *Command) (string, error)
+}
+
+// DefaultRunner runs every command started with Run
+var DefaultRunner Runner = Exec{}
+
+// Run runs cmd with the DefaultRunner
+func Run(cmd *Command) (string, error) {
+	return DefaultRunner.Run(cmd)
+}
+
+// Exec runs commands with os/exec
+type Exec struct{}
+
+func (Exec) Run(cmd *Command) (string, error) {
+	c := exec.Command(cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Stdin = cmd.Stdin
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+
+	var output bytes.Buffer
+	var writer io.Writer = &output
+	if cmd.Stream != nil {
+		writer = io.MultiWriter(&output, cmd.Stream)
+	}
+	c.Stdout, c.Stderr = writer, writer
+	if cmd.Stderr != nil {
+		c.Stderr = cmd.Stderr
+	}
+
+	err := c.Run()
+	return output.String(), err
+}
+
+// ExitError is returned by replayed commands that exited with a non-zero
+// status
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// ExitCode returns the exit status in err, or -1 if the command didn't run
+func ExitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Split splits line into a command name and arguments the way a shell
+// would, without expanding anything.  Arguments can be quoted with ' or ",
+// and a backslash escapes the next character outside of single quotes
+func Split(line string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg, escaped := false, false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case escaped:
+			// within double quotes only the special characters are escaped
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated %c quote in %q", quote, line)
+	} else if escaped {
+		return nil, fmt.Errorf("Trailing backslash in %q", line)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRunner map[string]Call
+
+func (t testRunner) Run(cmd *Command) (string, error) {
+	call := t[cmd.Name]
+	if cmd.Stream != nil {
+		cmd.Stream.Write([]byte(call.Output))
+	}
+
+	if cmd.Stderr != nil {
+		cmd.Stderr.Write([]byte(call.Stderr))
+	}
+
+	if call.ExitCode != 0 {
+		return call.Output, &ExitError{call.ExitCode}
+	}
+	return call.Output, nil
+}
+
+func TestExec(t *testing.T) {
+	var stderr bytes.Buffer
+	output, err := Exec{}.Run(&Command{Name: "sh", Args: []string{"-c", "echo out; echo err >&2; exit 3"}, Stderr: &stderr})
+	if output != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("Expected stdout %q and stderr %q but got %q and %q", "out\n", "err\n", output, stderr.String())
+	}
+
+	if ExitCode(err) != 3 {
+		t.Errorf("Expected exit code 3 but got %v", err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	secret := `zq&<c>"x`
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	commands := []*Command{
+		{Name: "go", Args: []string{"version"}},
+		{Name: "git", Args: []string{"rev-parse", "HEAD"}, Dir: "src"},
+		{Name: "gpg", Args: []string{"--sign"}, Env: []string{"GNUPGHOME=" + secret}, Stdin: strings.NewReader("input")},
+		{Name: "describe", Stderr: ioutil.Discard},
+	}
+
+	recorder := NewRecorder(testRunner{
+		"go":       {Output: "go version go1.20 linux/amd64\n"},
+		"git":      {Output: "fatal: not a git repository\n", ExitCode: 128},
+		"gpg":      {Output: "signed by " + secret + "\n"},
+		"describe": {Output: "v1.0.0\n", Stderr: "warning: " + secret + "\n"},
+	})
+	recorder.Redact = func(str string) string { return strings.Replace(str, secret, "***", -1) }
+
+	for _, cmd := range commands {
+		recorder.Run(cmd)
+	}
+
+	filename := filepath.Join(dir, "calls.json")
+	if err := recorder.Save(filename); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the secret is escaped when it is encoded
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	} else if strings.Contains(string(data), "zq") {
+		t.Errorf("Expected the secret to be redacted from:\n%s", data)
+	}
+
+	replay, err := LoadReplay(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	commands[2].Env = []string{"GNUPGHOME=***"}
+	commands[2].Stdin = strings.NewReader("input")
+	var stream bytes.Buffer
+	commands[2].Stream = &stream
+	var stderr bytes.Buffer
+	commands[3].Stderr = &stderr
+
+	outputs := []string{}
+	for _, cmd := range commands {
+		output, err := replay.Run(cmd)
+		if cmd.Name == "git" && ExitCode(err) != 128 {
+			t.Errorf("Expected exit code 128 but got %v", err)
+		} else if cmd.Name != "git" && err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	expected := []string{"go version go1.20 linux/amd64\n", "fatal: not a git repository\n", "signed by ***\n", "v1.0.0\n"}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected outputs %q but got %q", expected, outputs)
+	}
+
+	if stream.String() != expected[2] {
+		t.Errorf("Expected %q to be streamed but got %q", expected[2], stream.String())
+	}
+
+	if stderr.String() != "warning: ***\n" {
+		t.Errorf("Expected %q to be written to stderr but got %q", "warning: ***\n", stderr.String())
+	}
+
+	if _, err := replay.Run(commands[0]); err == nil {
+		t.Errorf("Expected an error for a command that was not recorded")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		err      bool
+	}{
+		{"pass show signing-key", []string{"pass", "show", "signing-key"}, false},
+		{`pass show "my key"`, []string{"pass", "show", "my key"}, false},
+		{`sh -c 'echo "$KEY"'`, []string{"sh", "-c", `echo "$KEY"`}, false},
+		{`echo "a \"b\" \n" c\ d ''`, []string{"echo", `a "b" \n`, "c d", ""}, false},
+		{"  echo\ta  ", []string{"echo", "a"}, false},
+		{"", []string{}, false},
+		{`pass show "my key`, nil, true},
+		{`echo a\`, nil, true},
+	}
+
+	for _, test := range tests {
+		args, err := Split(test.line)
+		if test.err && err == nil {
+			t.Errorf("%q: Expected an error", test.line)
+		} else if !test.err && err != nil {
+			t.Errorf("%q: Unexpected error: %v", test.line, err)
+		} else if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%q: Expected %q but got %q", test.line, test.expected, args)
+		}
+	}
+}
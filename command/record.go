@@ -0,0 +1,213 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Call is a command that was run and what it did
+type Call struct {
+	Name  string   `json:"name"`
+	Args  []string `json:"args,omitempty"`
+	Env   []string `json:"env,omitempty"`
+	Dir   string   `json:"dir,omitempty"`
+	Stdin string   `json:"stdin,omitempty"`
+
+	Output string `json:"output,omitempty"`
+
+	// Stderr is what the command wrote to its Stderr, when it was set.
+	// Otherwise stderr is part of the output
+	Stderr string `json:"stderr,omitempty"`
+
+	// ExitCode is zero for commands that succeeded and -1 for commands
+	// that could not be run
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// Error is the message of the error from a command that could not be run
+	Error string `json:"error,omitempty"`
+}
+
+func newCall(cmd *Command) *Call {
+	return &Call{Name: cmd.Name, Args: cmd.Args, Env: cmd.Env, Dir: cmd.Dir}
+}
+
+func (c *Call) matches(cmd *Command) bool {
+	return c.Name == cmd.Name && reflect.DeepEqual(c.Args, nilIfEmpty(cmd.Args)) &&
+		reflect.DeepEqual(c.Env, nilIfEmpty(cmd.Env)) && c.Dir == cmd.Dir
+}
+
+func nilIfEmpty(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// redact masks every string in the call.  The strings are masked before
+// they are encoded, since encoding escapes some characters
+func (c *Call) redact(redact func(string) string) {
+	for _, list := range []*[]string{&c.Args, &c.Env} {
+		if *list != nil {
+			redacted := make([]string, len(*list))
+			for i, str := range *list {
+				redacted[i] = redact(str)
+			}
+			*list = redacted
+		}
+	}
+
+	for _, str := range []*string{&c.Name, &c.Dir, &c.Stdin, &c.Output, &c.Stderr, &c.Error} {
+		*str = redact(*str)
+	}
+}
+
+func (c *Call) String() string {
+	return strings.Join(append(append([]string{}, c.Env...), append([]string{c.Name}, c.Args...)...), " ")
+}
+
+// Recorder runs commands with another Runner and records each call
+type Recorder struct {
+	Runner Runner
+
+	// Redact, when set, masks anything that must not be saved
+	Redact func(string) string
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewRecorder records the commands run by runner
+func NewRecorder(runner Runner) *Recorder {
+	return &Recorder{Runner: runner}
+}
+
+func (r *Recorder) Run(cmd *Command) (string, error) {
+	call := newCall(cmd)
+	copied := *cmd
+	if cmd.Stdin != nil {
+		stdin, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return "", err
+		}
+		call.Stdin = string(stdin)
+		copied.Stdin = strings.NewReader(call.Stdin)
+	}
+
+	var stderr bytes.Buffer
+	if cmd.Stderr != nil {
+		copied.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	}
+
+	output, err := r.Runner.Run(&copied)
+	call.Output, call.Stderr = output, stderr.String()
+	if err != nil {
+		call.ExitCode = ExitCode(err)
+		if call.ExitCode == -1 {
+			call.Error = err.Error()
+		}
+	}
+
+	r.mu.Lock()
+	r.calls = append(r.calls, *call)
+	r.mu.Unlock()
+	return output, err
+}
+
+// Calls returns the calls recorded so far
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Save writes the recorded calls to a file that can be loaded by LoadReplay
+func (r *Recorder) Save(filename string) error {
+	calls := r.Calls()
+	if r.Redact != nil {
+		for i := range calls {
+			calls[i].redact(r.Redact)
+		}
+	}
+
+	data, err := json.MarshalIndent(calls, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filename, append(data, '\n'), 0644)
+	}
+	return err
+}
+
+// Replay is a fake Runner that plays back calls instead of running
+// anything.  Commands must be run in the same order as the calls
+type Replay struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewReplay plays back calls
+func NewReplay(calls ...Call) *Replay {
+	return &Replay{calls: calls}
+}
+
+// LoadReplay plays back the calls saved by a Recorder
+func LoadReplay(filename string) (*Replay, error) {
+	calls := []Call{}
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(data, &calls)
+	}
+	return NewReplay(calls...), err
+}
+
+func (r *Replay) Run(cmd *Command) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	call := newCall(cmd)
+	if len(r.calls) == 0 {
+		return "", fmt.Errorf("Unexpected command %v", call)
+	}
+
+	next := r.calls[0]
+	if !next.matches(cmd) {
+		return "", fmt.Errorf("Unexpected command %v, expected %v", call, &next)
+	}
+
+	if cmd.Stdin != nil {
+		stdin, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return "", err
+		} else if string(stdin) != next.Stdin {
+			return "", fmt.Errorf("Unexpected input to %v: %q, expected %q", call, stdin, next.Stdin)
+		}
+	}
+	r.calls = r.calls[1:]
+
+	if cmd.Stream != nil {
+		io.WriteString(cmd.Stream, next.Output)
+	}
+
+	if cmd.Stderr != nil {
+		io.WriteString(cmd.Stderr, next.Stderr)
+	}
+
+	if next.Error != "" {
+		return next.Output, errors.New(next.Error)
+	} else if next.ExitCode != 0 {
+		return next.Output, &ExitError{next.ExitCode}
+	}
+	return next.Output, nil
+}
+
+// Remaining returns the calls that have not been played back
+func (r *Replay) Remaining() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
@@ -1,28 +1,50 @@
 package gack
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/mitchellh/mapstructure"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	ErrConfigKeyNotFound = errors.New("Config key not found")
+	ErrProfileNotFound   = errors.New("Profile not found")
+	ErrConfigNotFound    = errors.New("Config file not found")
 )
 
+// ConfigFileNames are the file names searched for by FindConfigFile, in
+// order of preference
+var ConfigFileNames = []string{"gack.yml", "gack.yaml", "gack.toml", "gack.json"}
+
 type Config struct {
-	PackageName      string                 `yaml:"package_name"`
-	Version          string                 `yaml:"version"`
-	Maintainer       string                 `yaml:"maintainer"`
-	MaintainerEmail  string                 `yaml:"maintainer_email"`
-	Homepage         string                 `yaml:"homepage"`
-	ShortDescription string                 `yaml:"short_description"`
-	Description      string                 `yaml:"description"`
+	PackageName      string                 `yaml:"package_name" desc:"Name of the project's package"`
+	Version          string                 `yaml:"version" desc:"Version of the project, or auto to derive it from git tags or a VERSION file"`
+	Maintainer       string                 `yaml:"maintainer" desc:"Name of the package maintainer"`
+	MaintainerEmail  string                 `yaml:"maintainer_email" desc:"Email address of the package maintainer"`
+	Homepage         string                 `yaml:"homepage" desc:"Project homepage URL"`
+	ShortDescription string                 `yaml:"short_description" desc:"One line description of the package"`
+	Description      string                 `yaml:"description" desc:"Full description of the package"`
+	Binaries         []Binary               `yaml:"binaries,omitempty" desc:"Programs built by the project, defaults to a single binary named after the package"`
+	Profiles         map[string]interface{} `yaml:"profiles,omitempty" desc:"Named config overlays selected with --profile or GACK_PROFILE"`
 	Targets          map[string]interface{} `yaml:",inline"`
+
+	node     *yaml.Node
+	files    map[*yaml.Node]string
+	filename string
+
+	// derivedVersion is set once the version has been derived by
+	// ResolveVersion
+	derivedVersion bool
 }
 
 func NewConfig() *Config {
@@ -43,32 +65,171 @@ func decodeConfig(input, output interface{}) error {
 	return err
 }
 
+// Get decodes the named section of the config into output.  The section
+// is strictly validated against output first, so unknown keys and values
+// of the wrong type are reported along with their position in the file
 func (c *Config) Get(name string, output interface{}) error {
 	input, found := c.Targets[name]
 	if found {
-		return decodeConfig(input, output)
+		err := c.validateSection(name, output)
+		if err == nil {
+			err = decodeConfig(input, output)
+		}
+		return err
 	}
 	return ErrConfigKeyNotFound
 }
 
+// FindConfigFile searches dir and then each of its parent directories for
+// one of the ConfigFileNames.  The directory containing the config file
+// is the root of the project
+func FindConfigFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		for _, name := range ConfigFileNames {
+			filename := filepath.Join(dir, name)
+			if _, err := os.Stat(filename); err == nil {
+				return filename, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrConfigNotFound
+		}
+		dir = parent
+	}
+}
+
+// parseConfig parses the content of a config file into a yaml node.  The
+// format of the file is determined by its extension.  JSON is parsed as
+// yaml, which it is a subset of.  Values read from TOML files do not have
+// line numbers
+func parseConfig(filename string, data []byte) (*yaml.Node, error) {
+	if filepath.Ext(filename) == ".toml" {
+		var value map[string]interface{}
+		var node yaml.Node
+		err := toml.Unmarshal(data, &value)
+		if err == nil {
+			err = node.Encode(value)
+		}
+		return &node, err
+	}
+
+	var document yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err != nil || len(document.Content) == 0 {
+		return nil, err
+	}
+	return document.Content[0], nil
+}
+
+// ReadConfigFile reads the config from filename along with any
+// files it includes
 func ReadConfigFile(filename string) (*Config, error) {
 	config := NewConfig()
-	configFile, err := os.Open(filename)
-	if err == nil {
-		err = ReadConfig(config, configFile)
+	loader := newConfigLoader()
+	node, err := loader.load(filename)
+	config.filename = filename
+	if err == nil && node != nil {
+		config.files = loader.files
+		err = config.decode(node)
 	}
 	return config, err
 }
 
+// Filename returns the name of the file the config was read from
+func (c *Config) Filename() string {
+	return c.filename
+}
+
 func ReadConfig(config *Config, reader io.Reader) error {
+	var document yaml.Node
 	data, err := ioutil.ReadAll(reader)
 	if err == nil {
-		err = yaml.Unmarshal(data, config)
+		err = yaml.Unmarshal(data, &document)
 	}
 
+	if err == nil && len(document.Content) > 0 {
+		err = config.decode(document.Content[0])
+	}
 	return err
 }
 
+func (c *Config) decode(node *yaml.Node) error {
+	c.node = node
+	err := node.Decode(c)
+	if err != nil {
+		// report the problems with their positions when possible
+		if verr := c.validate(nil, false); verr != nil {
+			err = verr
+		}
+	}
+	return err
+}
+
+// ApplyProfile deep merges the named section of the config's profiles
+// over the rest of the config.  Mappings are merged key by key, any other
+// value in the profile replaces the value in the base config
+func (c *Config) ApplyProfile(name string) error {
+	profile := lookupNode(lookupNode(c.node, "profiles"), name)
+	if profile == nil {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+
+	config := NewConfig()
+	config.files = c.files
+	config.filename = c.filename
+	err := config.decode(mergeNodes(c.node, profile))
+	if err == nil {
+		*c = *config
+	}
+	return err
+}
+
+// lookupNode returns the value for key in a yaml mapping node
+func lookupNode(node *yaml.Node, key string) *yaml.Node {
+	if node != nil && node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	}
+	return nil
+}
+
+// mergeNodes returns a new node with overlay merged on top of base.  Neither
+// base nor overlay are modified
+func mergeNodes(base, overlay *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return &merged
+}
+
 func DefaultConfig(mux Mux) *Config {
 	defaultConfig := NewConfig()
 
@@ -80,19 +241,148 @@ func DefaultConfig(mux Mux) *Config {
 	defaultConfig.ShortDescription = "short description"
 	defaultConfig.Description = "description"
 
-	for _, target := range mux.targets {
-		if configurable, ok := target.Executable.(DefaultConfigurable); ok {
-			name, config := configurable.DefaultConfig()
-			defaultConfig.Targets[name] = config
-		}
+	for name, config := range mux.ConfigSections() {
+		defaultConfig.Targets[name] = config
 	}
 	return defaultConfig
 }
 
+// MergeConfig adds the sections and keys of defaults that are missing from
+// the yaml document in data.  Values that are already in the document are
+// kept as they are, along with the order of keys and any comments.  Keys
+// that are added are commented with their documentation, and keys that
+// are empty by default are added as commented out entries
+func MergeConfig(data []byte, defaults *Config) ([]byte, error) {
+	var document, node yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err == nil {
+		err = node.Encode(defaults)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	options := DescribeConfig(defaults)
+	commentNode(&node, options)
+
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&node}}
+	} else {
+		addMissing(document.Content[0], &node, reflect.ValueOf(defaults))
+	}
+	commentMissing(document.Content[0], options)
+	return encodeDocument(&document)
+}
+
+// SetConfig sets the value of key, a dot separated path, in the yaml document
+// in data.  The value is parsed as yaml and any mappings missing from the
+// path are created.  The rest of the document is left untouched
+func SetConfig(data []byte, key, value string) ([]byte, error) {
+	var document, node yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err == nil {
+		err = yaml.Unmarshal([]byte(value), &node)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	if len(node.Content) == 0 {
+		node.Content = []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!null"}}
+	}
+
+	parent := document.Content[0]
+	path := strings.Split(key, ".")
+	for i, name := range path {
+		if parent.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("Cannot set %s, %s is not a mapping", key, strings.Join(path[:i], "."))
+		}
+
+		child := lookupNode(parent, name)
+		if i == len(path)-1 {
+			if child == nil {
+				parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, node.Content[0])
+			} else {
+				// keep the comments that were on the old value
+				value := *node.Content[0]
+				value.HeadComment, value.LineComment, value.FootComment = child.HeadComment, child.LineComment, child.FootComment
+				*child = value
+			}
+		} else if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+		}
+		parent = child
+	}
+	return encodeDocument(&document)
+}
+
+// LookupNode returns the value of key, a dot separated path, in node or nil
+// if the key is not found
+func LookupNode(node *yaml.Node, key string) *yaml.Node {
+	for _, name := range strings.Split(key, ".") {
+		if node = lookupNode(node, name); node == nil {
+			break
+		}
+	}
+	return node
+}
+
+func encodeDocument(document *yaml.Node) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err := encoder.Encode(document)
+	if err == nil {
+		err = encoder.Close()
+	}
+	return buffer.Bytes(), err
+}
+
+// addMissing adds keys from defaults to node.  Only the fields of structs
+// are added, maps and lists in the defaults are values that the user has
+// already chosen to replace
+func addMissing(node, defaults *yaml.Node, value reflect.Value) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+
+	if node.Kind != yaml.MappingNode || defaults.Kind != yaml.MappingNode || value.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i+1 < len(defaults.Content); i += 2 {
+		key, defaultValue := defaults.Content[i], defaults.Content[i+1]
+		existing := lookupNode(node, key.Value)
+		if existing == nil {
+			node.Content = append(node.Content, key, defaultValue)
+		} else if field, found := fieldByKey(value.Type(), key.Value, "yaml"); found {
+			addMissing(existing, defaultValue, value.FieldByIndex(field.Index))
+		} else if inline := inlineMap(value); inline.IsValid() {
+			addMissing(existing, defaultValue, inline.MapIndex(reflect.ValueOf(key.Value)))
+		}
+	}
+}
+
+// inlineMap returns the map that yaml inlines into the struct value
+func inlineMap(value reflect.Value) reflect.Value {
+	if field, found := inlineField(value.Type(), "yaml"); found {
+		return value.FieldByIndex(field.Index)
+	}
+	return reflect.Value{}
+}
+
 func WriteConfig(writer io.Writer, config *Config) error {
-	data, err := yaml.Marshal(config)
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	err := encoder.Encode(config)
 	if err == nil {
-		_, err = writer.Write(data)
+		err = encoder.Close()
 	}
 	return err
 }
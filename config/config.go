@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/abates/gack"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	resolved = flag.Bool("resolved", false, "include the defaults of every config section in config dump")
+	format   = flag.String("format", "yaml", "output format of config dump and config get (yaml or json)")
+)
+
+func sources(ctx *gack.Context) error {
+	writer := tabwriter.NewWriter(gack.Stdout, 0, 8, 2, ' ', 0)
+	for _, source := range ctx.Config.Sources() {
+		fmt.Fprintf(writer, "%s\t%v\n", source.Key, source)
+	}
+	return writer.Flush()
+}
+
+func validate(mux *gack.Mux) gack.ExecuteFunc {
+	return func(ctx *gack.Context) error {
+		err := ctx.Config.Validate(mux.ConfigSections())
+		if err == nil {
+			fmt.Fprintf(gack.Stdout, "Config is valid\n")
+		}
+		return err
+	}
+}
+
+// resolve returns the effective config, with every registered section
+// decoded over its defaults
+func resolve(mux *gack.Mux) (*gack.Config, error) {
+	config := *mux.Config
+	config.Targets = make(map[string]interface{})
+	for name, section := range mux.Config.Targets {
+		config.Targets[name] = section
+	}
+
+	for name, section := range mux.ConfigSections() {
+		err := mux.Config.Get(name, section)
+		if err != nil && err != gack.ErrConfigKeyNotFound {
+			return nil, err
+		}
+		config.Targets[name] = section
+	}
+	return &config, nil
+}
+
+func write(node *yaml.Node) error {
+	if *format == "json" {
+		var value interface{}
+		var data []byte
+		err := node.Decode(&value)
+		if err == nil {
+			data, err = json.MarshalIndent(value, "", "  ")
+		}
+
+		if err == nil {
+			fmt.Fprintf(gack.Stdout, "%s\n", data)
+		}
+		return err
+	} else if *format != "yaml" {
+		return fmt.Errorf("Unknown format %q", *format)
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		fmt.Fprintf(gack.Stdout, "%s\n", node.Value)
+		return nil
+	}
+
+	encoder := yaml.NewEncoder(gack.Stdout)
+	encoder.SetIndent(2)
+	err := encoder.Encode(node)
+	if err == nil {
+		err = encoder.Close()
+	}
+	return err
+}
+
+func dump(mux *gack.Mux) gack.ExecuteFunc {
+	return func(ctx *gack.Context) (err error) {
+		config := ctx.Config
+		if *resolved {
+			config, err = resolve(mux)
+		}
+
+		var node yaml.Node
+		if err == nil {
+			err = node.Encode(config)
+		}
+
+		if err == nil {
+			err = write(&node)
+		}
+		return err
+	}
+}
+
+func get(mux *gack.Mux) gack.ExecuteFunc {
+	return func(ctx *gack.Context) error {
+		var node yaml.Node
+		config, err := resolve(mux)
+		if err == nil {
+			err = node.Encode(config)
+		}
+
+		if err == nil {
+			value := gack.LookupNode(&node, ctx.Param("key"))
+			if value == nil {
+				return fmt.Errorf("%w: %s", gack.ErrConfigKeyNotFound, ctx.Param("key"))
+			}
+			err = write(value)
+		}
+		return err
+	}
+}
+
+func set(ctx *gack.Context) error {
+	filename := ctx.Config.Filename()
+	if ext := filepath.Ext(filename); ext != ".yml" && ext != ".yaml" {
+		return fmt.Errorf("Cannot set values in %s, only yaml config files can be updated", filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		data, err = gack.SetConfig(data, ctx.Param("key"), ctx.Param("value"))
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(filename, data, 0644)
+	}
+	return err
+}
+
+func reference(mux *gack.Mux) gack.ExecuteFunc {
+	return func(ctx *gack.Context) error {
+		return gack.WriteConfigReference(gack.Stdout, gack.DefaultConfig(*mux))
+	}
+}
+
+func Register(mux *gack.Mux) {
+	mux.Register("config/sources", gack.ExecuteFunc(sources))
+	mux.Register("config/validate", validate(mux))
+	mux.Register("config/dump", dump(mux))
+	mux.Register("config/reference", reference(mux))
+	mux.Register("config/get/:key", get(mux))
+	mux.Register("config/set/:key/:value", gack.ExecuteFunc(set))
+}
@@ -0,0 +1,509 @@
+package gack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abates/gack/command"
+)
+
+var profileConfig = `
+package_name: foo
+version: "1.0"
+build:
+  platforms:
+    linux: ["386", "amd64"]
+    windows-10: ["amd64"]
+profiles:
+  dev:
+    version: "1.0-dev"
+    build:
+      platforms:
+        linux: ["amd64"]
+`
+
+func TestApplyProfile(t *testing.T) {
+	config := NewConfig()
+	err := ReadConfig(config, strings.NewReader(profileConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = config.ApplyProfile("dev")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if config.PackageName != "foo" {
+		t.Errorf("Expected package name foo but got %q", config.PackageName)
+	}
+
+	if config.Version != "1.0-dev" {
+		t.Errorf("Expected version 1.0-dev but got %q", config.Version)
+	}
+
+	build := struct{ Platforms map[string][]string }{}
+	err = config.Get("build", &build)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{"linux": {"amd64"}, "windows-10": {"amd64"}}
+	if !reflect.DeepEqual(expected, build.Platforms) {
+		t.Errorf("Expected platforms %v but got %v", expected, build.Platforms)
+	}
+
+	if err = config.ApplyProfile("release"); err == nil {
+		t.Errorf("Expected an error for a missing profile")
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".git/HEAD":            "",
+		"org.yml":              "maintainer: Org\nhomepage: http://org.com\nbuild:\n  platforms:\n    linux: [amd64]\n",
+		"service/common.yml":   "extends: org.yml\nhomepage: http://service.com\n",
+		"service/app/gack.yml": "include: [../common.yml]\npackage_name: app\n",
+		"service/loop.yml":     "include: loop.yml\n",
+	}
+
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	config, err := ReadConfigFile(filepath.Join(dir, "service/app/gack.yml"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if config.PackageName != "app" || config.Maintainer != "Org" || config.Homepage != "http://service.com" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	expected := map[string]string{
+		"package_name":          "service/app/gack.yml",
+		"maintainer":            "org.yml",
+		"homepage":              "service/common.yml",
+		"build.platforms.linux": "org.yml",
+	}
+
+	sources := config.Sources()
+	if len(sources) != len(expected) {
+		t.Errorf("Expected %d sources but got %v", len(expected), sources)
+	}
+
+	for _, source := range sources {
+		if filepath.Join(dir, expected[source.Key]) != source.File {
+			t.Errorf("Expected %s to come from %s but got %s", source.Key, expected[source.Key], source.File)
+		}
+	}
+
+	if _, err = ReadConfigFile(filepath.Join(dir, "service/loop.yml")); err == nil {
+		t.Errorf("Expected an error for recursive includes")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type buildConfig struct {
+		Platforms map[string][]string
+		Native    bool `config_name:"native_build"`
+	}
+
+	config := NewConfig()
+	err := ReadConfig(config, strings.NewReader("package_name: foo\nversion: [1]\n"))
+	if err == nil || err.Error() != "2:10: version: Expected string but got a list" {
+		t.Errorf("Expected a positioned error but got %v", err)
+	}
+
+	input := `package_name: foo
+version: 1.0
+build:
+  pltforms:
+    linux: [amd64]
+  platforms:
+    linux: amd64
+  native_build: maybe
+pkg: {}
+`
+	config = NewConfig()
+	err = ReadConfig(config, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = config.Validate(map[string]interface{}{"build": &buildConfig{}})
+	expected := []string{
+		`4:3: build: Unknown key "pltforms"`,
+		`7:12: build.platforms.linux: Expected a list but got "amd64"`,
+		`8:17: build.native_build: Cannot use "maybe" as bool`,
+		`9:1: Unknown key "pkg"`,
+	}
+
+	if errs, ok := err.(ConfigErrors); ok {
+		got := []string{}
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Expected errors %q but got %q", expected, got)
+		}
+	} else {
+		t.Errorf("Expected ConfigErrors but got %v", err)
+	}
+
+	err = config.Get("build", &buildConfig{})
+	if errs, ok := err.(ConfigErrors); !ok || len(errs) != 3 {
+		t.Errorf("Expected 3 errors from Get but got %v", err)
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "cmd/foo"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "gack.toml"), []byte("package_name = \"foo\"\n[build.platforms]\nlinux = [\"amd64\"]\n"), 0644)
+
+	filename, err := FindConfigFile(filepath.Join(dir, "cmd/foo"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if filename != filepath.Join(dir, "gack.toml") {
+		t.Errorf("Expected %s but got %s", filepath.Join(dir, "gack.toml"), filename)
+	}
+
+	config, err := ReadConfigFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	build := struct{ Platforms map[string][]string }{}
+	err = config.Get("build", &build)
+	if config.PackageName != "foo" || err != nil || !reflect.DeepEqual(build.Platforms["linux"], []string{"amd64"}) {
+		t.Errorf("Unexpected config %+v %v (%v)", config, build, err)
+	}
+}
+
+func TestParseDescribe(t *testing.T) {
+	tests := []struct {
+		describe string
+		expected string
+	}{
+		{"v1.2.3-0-g1a2b3c4", "1.2.3"},
+		{"v1.2.3-0-g1a2b3c4-dirty", "1.2.3+dirty"},
+		{"1.2.3-4-g1a2b3c4", "1.2.3+4.g1a2b3c4"},
+		{"v1.2.3-rc1-4-g1a2b3c4-dirty", "1.2.3-rc1+4.g1a2b3c4.dirty"},
+		{"v1.2.3+meta-4-g1a2b3c4", "1.2.3+meta.4.g1a2b3c4"},
+	}
+
+	for i, test := range tests {
+		version, err := parseDescribe(test.describe)
+		if err != nil || version != test.expected {
+			t.Errorf("Test %d: Expected %s but got %s (%v)", i, test.expected, version, err)
+		}
+	}
+
+	if _, err := parseDescribe("1a2b3c4"); err == nil {
+		t.Errorf("Expected an error for output without a tag")
+	}
+}
+
+func TestDebianVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"1.2.3", "1.2.3"},
+		{"1.2.3-rc1", "1.2.3~rc1"},
+		{"1.2.3-rc1+4.g1a2b3c4.dirty", "1.2.3~rc1+4.g1a2b3c4.dirty"},
+		{"1.2.3+4.g1a2b3c4", "1.2.3+4.g1a2b3c4"},
+	}
+
+	for _, test := range tests {
+		if version := DebianVersion(test.version); version != test.expected {
+			t.Errorf("%s: Expected %s but got %s", test.version, test.expected, version)
+		}
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gack.yml")
+	err = ioutil.WriteFile(filename, []byte("package_name: foo\nversion: 1.0\nprofiles:\n  snapshot:\n    version: auto\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// git is only run once the profile has been applied
+	replay := command.NewReplay(command.Call{
+		Name:   "git",
+		Args:   []string{"describe", "--tags", "--long", "--dirty"},
+		Dir:    dir,
+		Output: "v1.2.3-rc1-4-g1a2b3c4\n",
+	})
+	runner := command.DefaultRunner
+	defer func() { command.DefaultRunner = runner }()
+	command.DefaultRunner = replay
+
+	config, err := ReadConfigFile(filename)
+	if err == nil {
+		err = config.ApplyProfile("snapshot")
+	}
+
+	if err == nil && config.Version != AutoVersion {
+		t.Errorf("Expected the version to be derived later but got %q", config.Version)
+	}
+
+	if err == nil {
+		err = config.ResolveVersion()
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if config.Version != "1.2.3-rc1+4.g1a2b3c4" || config.PackageVersion() != "1.2.3~rc1+4.g1a2b3c4" {
+		t.Errorf("Expected version 1.2.3-rc1+4.g1a2b3c4 but got %q and package version %q", config.Version, config.PackageVersion())
+	}
+
+	if remaining := replay.Remaining(); len(remaining) > 0 {
+		t.Errorf("Expected git describe to be run but got %v", remaining)
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	input := `# project settings
+version: "2.0" # keep me
+package_name: mine
+build:
+  platforms:
+    linux: [amd64]
+`
+	defaults := NewConfig()
+	defaults.PackageName = "package"
+	defaults.Version = "version"
+	defaults.Targets["build"] = &struct {
+		Platforms map[string][]string
+		Tags      []string
+	}{
+		Platforms: map[string][]string{"windows": {"amd64"}},
+		Tags:      []string{},
+	}
+
+	output, err := MergeConfig([]byte(input), defaults)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `# project settings
+version: "2.0" # keep me
+package_name: mine
+build:
+  platforms:
+    linux: [amd64]
+  # type: list of string, default: []
+  tags: []
+# Name of the package maintainer
+# type: string
+maintainer: ""
+# Email address of the package maintainer
+# type: string
+maintainer_email: ""
+# Project homepage URL
+# type: string
+homepage: ""
+# One line description of the package
+# type: string
+short_description: ""
+# Full description of the package
+# type: string
+description: ""
+# Programs built by the project, defaults to a single binary named after the package
+# type: list of mapping
+# binaries:
+#   - name: # Name of the binary
+#     path: # Path to the binary's main package, defaults to the project root
+#     package: # Package the binary is bundled in, defaults to package_name
+# Named config overlays selected with --profile or GACK_PROFILE
+# type: map of string to any
+# profiles:
+`
+	if string(output) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, output)
+	}
+
+	// the commented out entries are not added again
+	if again, err := MergeConfig(output, defaults); err != nil || string(again) != expected {
+		t.Errorf("Expected merging again to not change the output but got:\n%s\n(%v)", again, err)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	input := "version: \"1.0\" # the version\nbuild:\n  platforms:\n    linux: [\"386\"]\n"
+	tests := []struct {
+		key      string
+		value    string
+		expected string
+	}{
+		{"version", "2.0", "version: 2.0 # the version\nbuild:\n  platforms:\n    linux: [\"386\"]\n"},
+		{"build.platforms.linux", "[amd64]", "version: \"1.0\" # the version\nbuild:\n  platforms:\n    linux: [amd64]\n"},
+		{"pkg.deb.section", "utils", "version: \"1.0\" # the version\nbuild:\n  platforms:\n    linux: [\"386\"]\npkg:\n  deb:\n    section: utils\n"},
+	}
+
+	for i, test := range tests {
+		output, err := SetConfig([]byte(input), test.key, test.value)
+		if err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		} else if string(output) != test.expected {
+			t.Errorf("Test %d: Expected:\n%s\nGot:\n%s", i, test.expected, output)
+		}
+	}
+
+	if _, err := SetConfig([]byte(input), "version.major", "1"); err == nil {
+		t.Errorf("Expected an error setting a key inside a scalar")
+	}
+}
+
+func TestBinaries(t *testing.T) {
+	input := `package_name: tools
+build:
+  platforms:
+    linux: [amd64]
+binaries:
+  - name: server
+    path: ./cmd/server
+    package: tools-server
+  - name: cli_tool
+    path: ./cmd/cli
+    build:
+      platforms:
+        windows: [amd64]
+`
+	config := NewConfig()
+	err := ReadConfig(config, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = config.Validate(map[string]interface{}{"build": &struct{ Platforms map[string][]string }{}})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expected := []Binary{
+		{Name: "server", Path: "./cmd/server", Package: "tools-server"},
+		{Name: "cli_tool", Path: "./cmd/cli", Package: "tools", Sections: config.Binaries[1].Sections},
+	}
+
+	if !reflect.DeepEqual(expected, config.Executables()) {
+		t.Errorf("Expected binaries %+v but got %+v", expected, config.Executables())
+	}
+
+	packages := config.Packages()
+	if len(packages) != 2 || len(packages["tools"]) != 1 || packages["tools"][0].Name != "cli_tool" {
+		t.Errorf("Unexpected packages %+v", packages)
+	}
+
+	build := struct{ Platforms map[string][]string }{}
+	err = config.GetBinary(config.Binaries[1], "build", &build)
+	expectedPlatforms := map[string][]string{"windows": {"amd64"}}
+	if err != nil || !reflect.DeepEqual(expectedPlatforms, build.Platforms) {
+		t.Errorf("Expected platforms %v but got %v (%v)", expectedPlatforms, build.Platforms, err)
+	}
+
+	config = NewConfig()
+	config.PackageName = "foo"
+	expected = []Binary{{Name: "foo", Path: "./", Package: "foo"}}
+	if !reflect.DeepEqual(expected, config.Executables()) {
+		t.Errorf("Expected binaries %+v but got %+v", expected, config.Executables())
+	}
+}
+
+func TestValidateBinaries(t *testing.T) {
+	input := `package_name: tools
+binaries:
+  - name: server
+    pth: ./cmd/server
+  - name: cli_tool
+    bild:
+      platforms:
+        linux: [amd64]
+    build:
+      platforms:
+        windows: amd64
+`
+	config := NewConfig()
+	err := ReadConfig(config, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = config.Validate(map[string]interface{}{"build": &struct{ Platforms map[string][]string }{}})
+	expected := []string{
+		`4:5: binaries: Unknown key "pth"`,
+		`6:5: binaries: Unknown key "bild"`,
+		`11:18: binaries.build.platforms.windows: Expected a list but got "amd64"`,
+	}
+
+	got := []string{}
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+	}
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected errors %q but got %v", expected, err)
+	}
+}
+
+func TestConfigReference(t *testing.T) {
+	defaults := NewConfig()
+	defaults.PackageName = "package"
+	defaults.Targets["build"] = &struct {
+		Platforms map[string][]string `desc:"Platforms to build"`
+		Backend   string              `yaml:"backend" desc:"Build backend" allowed:"native,xgo"`
+	}{Backend: "native"}
+
+	var buffer bytes.Buffer
+	err := WriteConfigReference(&buffer, defaults)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"| `package_name` | string | `package` |  | Name of the project's package |",
+		"| `binaries[].name` | string |  |  | Name of the binary |",
+		"| `build.platforms` | map of string to list of string |  |  | Platforms to build |",
+		"| `build.backend` | string | `native` | native, xgo | Build backend |",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(buffer.String(), line+"\n") {
+			t.Errorf("Expected reference to contain %q\n%s", line, buffer.String())
+		}
+	}
+}
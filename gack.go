@@ -12,6 +12,13 @@ type Context struct {
 	Config *Config
 }
 
+// Planner is implemented by targets that can show what they would do
+// without doing it.  During a dry run Plan is called in place of Execute,
+// and targets that are not Planners are only listed
+type Planner interface {
+	Plan(*Context) error
+}
+
 func (c *Context) Param(name string) string {
 	return c.Target.Param(name)
 }
@@ -37,23 +44,35 @@ type Target struct {
 }
 
 type Mux struct {
-	Config      *Config
+	Config *Config
+
+	// DryRun shows the plan for a target rather than executing it
+	DryRun bool
+
 	targets     map[string]*Target
 	targetNames []string
+	index       *indexNode
 }
 
 func NewMux() (mux *Mux, err error) {
+	return NewMuxFromFile(configFile)
+}
+
+// NewMuxFromFile creates a Mux with the config read from filename.  The
+// Mux is always returned, with an empty config if the file could not be
+// read
+func NewMuxFromFile(filename string) (mux *Mux, err error) {
 	mux = &Mux{
 		targets: make(map[string]*Target),
+		index:   newIndexNode(""),
 	}
 
-	mux.Config, err = ReadConfigFile(configFile)
+	mux.Config, err = ReadConfigFile(filename)
 	return mux, err
 }
 
 func (mux *Mux) Lookup(input string) (*Target, []string, *Match) {
-	for _, targetName := range mux.targetNames {
-		target := mux.targets[targetName]
+	for _, target := range mux.index.lookup(input) {
 		if match := target.pattern.Match(input); match.Matches() {
 			return target, target.dependencies, &match
 		}
@@ -82,8 +101,13 @@ func (mux *Mux) Register(pattern string, executable Executable, dependencies ...
 	} else {
 		target = &Target{executable, NewPattern(pattern), dependencies}
 		mux.targets[pattern] = target
-		mux.targetNames = append(mux.targetNames, pattern)
-		sort.Sort(sort.Reverse(sort.StringSlice(mux.targetNames)))
+		mux.index.insert(target.pattern.prefix(), target)
+
+		// keep targetNames in reverse order without resorting the whole list
+		i := sort.Search(len(mux.targetNames), func(i int) bool { return mux.targetNames[i] < pattern })
+		mux.targetNames = append(mux.targetNames, "")
+		copy(mux.targetNames[i+1:], mux.targetNames[i:])
+		mux.targetNames[i] = pattern
 	}
 	return target, nil
 }
@@ -102,14 +126,38 @@ func (mux *Mux) Execute(subject string) (err error) {
 		}
 
 		if err == nil && target.Executable != nil {
-			err = target.Execute(&Context{
+			context := &Context{
 				Target: match,
-			})
+				Config: mux.Config,
+			}
+
+			if !mux.DryRun {
+				err = target.Execute(context)
+			} else if planner, ok := target.Executable.(Planner); ok {
+				err = planner.Plan(context)
+			} else {
+				Logf("Would run %s\n", subject)
+			}
 		}
 	}
 	return err
 }
 
+// ConfigSections returns the default config of every registered target
+// that is DefaultConfigurable, keyed by the section name
+func (mux *Mux) ConfigSections() map[string]interface{} {
+	sections := make(map[string]interface{})
+	for _, target := range mux.targets {
+		if configurable, ok := target.Executable.(DefaultConfigurable); ok {
+			name, config := configurable.DefaultConfig()
+			if config != nil {
+				sections[name] = config
+			}
+		}
+	}
+	return sections
+}
+
 func (mux *Mux) TargetNames() []string {
 	return mux.targetNames
 }
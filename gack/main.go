@@ -1,47 +1,193 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/abates/gack"
 	"github.com/abates/gack/build"
+	"github.com/abates/gack/checksums"
+	"github.com/abates/gack/command"
+	"github.com/abates/gack/config"
 	"github.com/abates/gack/generator"
 	"github.com/abates/gack/pkg"
+	"github.com/abates/gack/sbom"
 )
 
 var mux *gack.Mux
 
+var (
+	profile    = flag.String("profile", os.Getenv("GACK_PROFILE"), "name of the config profile to apply (defaults to $GACK_PROFILE)")
+	dryRun     = flag.Bool("n", false, "show what would be done without doing it")
+	record     = flag.String("record", "", "record the commands that are run to this file, for replaying in tests")
+	configFile string
+	directory  string
+	recorder   *command.Recorder
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "", "config file to use instead of searching for one")
+	flag.StringVar(&configFile, "config", "", "same as -f")
+	flag.StringVar(&directory, "C", "", "change to this directory before doing anything")
+	flag.StringVar(&directory, "directory", "", "same as -C")
+}
+
+// chdirProject finds the config file and changes to the directory containing
+// it, so that all paths used by targets are relative to the project root
+func chdirProject() (filename string, err error) {
+	if directory != "" {
+		if err = os.Chdir(directory); err != nil {
+			return "", err
+		}
+	}
+
+	filename = configFile
+	if filename == "" {
+		if filename, err = gack.FindConfigFile("."); err != nil {
+			return gack.ConfigFileNames[0], err
+		}
+	}
+
+	err = os.Chdir(filepath.Dir(filename))
+	return filepath.Base(filename), err
+}
+
 func usage(messages ...string) {
 	for _, message := range messages {
-		fmt.Fprintf(os.Stderr, "%s\n", message)
+		fmt.Fprintf(gack.Stderr, "%s\n", message)
+	}
+	fmt.Fprintf(gack.Stderr, "Usage: %s [options] target\n", os.Args[0])
+	fmt.Fprintf(gack.Stderr, "The words of the target are joined with /, so \"config sources\" is the same as config/sources.\n")
+	fmt.Fprintf(gack.Stderr, "Options may follow the target, words after -- are never options.\n")
+	fmt.Fprintf(gack.Stderr, "Options:\n")
+	flag.PrintDefaults()
+	if mux != nil {
+		fmt.Fprintf(gack.Stderr, "Available targets are:\n")
+		for _, target := range mux.TargetNames() {
+			fmt.Fprintf(gack.Stderr, "\t%v\n", target)
+		}
+	}
+	exit(1)
+}
+
+// exit writes any output held back by gack.Stdout and gack.Stderr and
+// exits with code
+func exit(code int) {
+	gack.FlushOutput()
+	os.Exit(code)
+}
+
+// parseArgs parses the command line, allowing options to be mixed in with
+// the words of the target, and returns the words.  Everything after -- is
+// a word, so that values such as -rc1 can be given
+func parseArgs() (args []string) {
+	input := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(input)
+		rest := flag.Args()
+		if consumed := len(input) - len(rest); consumed > 0 && input[consumed-1] == "--" {
+			return append(args, rest...)
+		} else if len(rest) == 0 {
+			return args
+		}
+		args = append(args, rest[0])
+		input = rest[1:]
+	}
+}
+
+// startRecording records every command run by the targets, the file is
+// written by saveRecording
+func startRecording() (err error) {
+	if *record != "" {
+		if *record, err = filepath.Abs(*record); err == nil {
+			recorder = command.NewRecorder(command.DefaultRunner)
+			recorder.Redact = gack.Redact
+			command.DefaultRunner = recorder
+		}
 	}
-	fmt.Fprintf(os.Stderr, "Usage: %s [target]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "Available targets are:\n")
-	for _, target := range mux.TargetNames() {
-		fmt.Fprintf(os.Stderr, "\t%v\n", target)
+	return err
+}
+
+func saveRecording() {
+	if recorder != nil {
+		if err := recorder.Save(*record); err != nil {
+			fmt.Fprintf(gack.Stderr, "WARNING: %v\n", err)
+		}
 	}
-	os.Exit(1)
 }
 
 func main() {
-	var err error
-	mux, err = gack.NewMux()
+	flag.CommandLine.SetOutput(gack.Stderr)
+	flag.Usage = func() { usage() }
+	args := parseArgs()
+
+	// the path is resolved before changing to the project directory
+	if err := startRecording(); err != nil {
+		fmt.Fprintf(gack.Stderr, "%v\n", err)
+		exit(1)
+	}
+
+	filename, err := chdirProject()
+	if err == nil {
+		mux, err = gack.NewMuxFromFile(filename)
+	} else if err == gack.ErrConfigNotFound {
+		mux, _ = gack.NewMuxFromFile(filename)
+	} else {
+		fmt.Fprintf(gack.Stderr, "%v\n", err)
+		exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(gack.Stderr, "WARNING: %v\n", err)
+	}
+
+	mux.DryRun = *dryRun
+	if *profile != "" {
+		if err = mux.Config.ApplyProfile(*profile); err != nil {
+			fmt.Fprintf(gack.Stderr, "%v\n", err)
+			exit(1)
+		}
+	}
+
+	if err = mux.Config.ResolveVersion(); err != nil {
+		fmt.Fprintf(gack.Stderr, "%v\n", err)
+		exit(1)
+	}
+
+	if err = build.Register(mux); err == nil {
+		config.Register(mux)
+		generator.Register(mux)
+		sbom.Register(mux)
+		checksums.Register(mux)
+		err = pkg.Register(mux)
+	}
+
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		fmt.Fprintf(gack.Stderr, "%v\n", err)
+		exit(1)
 	}
-	build.Register(mux)
-	generator.Register(mux)
-	pkg.Register(mux)
 
-	if len(os.Args) == 1 {
+	if len(args) == 0 {
 		usage()
 	}
 
-	err = mux.Execute(os.Args[1])
+	// "gack config sources" is the same as "gack config/sources"
+	err = mux.Execute(strings.Join(args, "/"))
+	saveRecording()
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		fmt.Fprintf(gack.Stderr, "%v\n", err)
+
+		// exit the same way as a failed build command
+		var cmdErr *build.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.ExitCode > 0 {
+			exit(cmdErr.ExitCode)
+		}
+		exit(1)
 	}
+	gack.FlushOutput()
 }
@@ -0,0 +1,142 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines computes the edits to turn a into b using the longest common
+// subsequence of lines.  Config files are small enough that the quadratic
+// table is not a problem
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		} else if i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diff returns a unified diff of the changes from a to b, or an empty
+// string if they are the same
+func diff(filename, a, b string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+	var buffer bytes.Buffer
+	for start := 0; start < len(lines); {
+		// find the next change
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+
+		if start == len(lines) {
+			break
+		}
+
+		// extend the hunk until there are more than two contexts worth of
+		// unchanged lines
+		end := start
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContext; end++ {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+
+		for end > start && lines[end-1].op == ' ' {
+			end--
+		}
+
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+
+		last := end + diffContext
+		if last > len(lines) {
+			last = len(lines)
+		}
+
+		if buffer.Len() == 0 {
+			fmt.Fprintf(&buffer, "--- %s\n+++ %s\n", filename, filename)
+		}
+
+		// line numbers of the hunk in a and b
+		aStart, bStart, aCount, bCount := 1, 1, 0, 0
+		for _, line := range lines[:first] {
+			if line.op != '+' {
+				aStart++
+			}
+			if line.op != '-' {
+				bStart++
+			}
+		}
+
+		for _, line := range lines[first:last] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+
+		// empty ranges refer to the line before the hunk
+		if aCount == 0 {
+			aStart--
+		}
+
+		if bCount == 0 {
+			bStart--
+		}
+
+		fmt.Fprintf(&buffer, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, line := range lines[first:last] {
+			fmt.Fprintf(&buffer, "%c%s\n", line.op, line.text)
+		}
+		start = last
+	}
+	return buffer.String()
+}
@@ -1,22 +1,55 @@
 package generator
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/abates/gack"
 )
 
-type generator gack.Mux
+// options of targets are command line flags, like -resolved and -format
+// of the config targets
+var showDiff = flag.Bool("diff", false, "show the changes generate would make to the config file instead of writing it")
+
+type generator struct {
+	mux *gack.Mux
+}
 
 func (g generator) Execute(ctx *gack.Context) (err error) {
-	config := gack.DefaultConfig(gack.Mux(g))
-	file, err := os.OpenFile("gack.yml", os.O_RDWR|os.O_CREATE, 0644)
+	filename := ctx.Config.Filename()
+	if filename == "" {
+		filename = gack.ConfigFileNames[0]
+	}
+
+	// the merged config is written as yaml
+	if ext := filepath.Ext(filename); ext != ".yml" && ext != ".yaml" {
+		return fmt.Errorf("Cannot generate into %s, only YAML config files can be updated", filename)
+	}
+
+	config := gack.DefaultConfig(*g.mux)
+	existing, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		existing, err = nil, nil
+	}
+
+	var updated []byte
+	if err == nil {
+		updated, err = gack.MergeConfig(existing, config)
+	}
+
 	if err == nil {
-		err = gack.WriteConfig(file, config)
+		if *showDiff {
+			fmt.Fprint(gack.Stdout, diff(filename, string(existing), string(updated)))
+		} else {
+			err = ioutil.WriteFile(filename, updated, 0644)
+		}
 	}
 	return err
 }
 
 func Register(mux *gack.Mux) {
-	mux.Register("generate", generator(*mux))
+	mux.Register("generate", generator{mux})
 }
@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abates/gack"
+)
+
+func TestGenerateConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+	}{
+		{"gack.yaml", "package_name: foo # the name\n", false},
+		{"gack.toml", "package_name = \"foo\"\n", true},
+		{"gack.json", "{\"package_name\": \"foo\"}\n", true},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(filename, []byte(test.content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		mux, err := gack.NewMuxFromFile(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		Register(mux)
+
+		err = mux.Execute("generate")
+		data, _ := ioutil.ReadFile(filename)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: Expected an error", test.name)
+			} else if string(data) != test.content {
+				t.Errorf("%s: Expected the file to be unchanged but got %q", test.name, data)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Unexpected error: %v", test.name, err)
+		} else if !strings.HasPrefix(string(data), test.content) || !strings.Contains(string(data), "version:") {
+			t.Errorf("%s: Expected the defaults to be merged into %q but got %q", test.name, test.content, data)
+		}
+		os.Remove(filename)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "gack.yml")); err == nil {
+		t.Errorf("Expected gack.yml to not be created")
+	}
+}
+
+func TestGenerateDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stdout := gack.Stdout
+	defer func() {
+		gack.Stdout = stdout
+		*showDiff = false
+	}()
+
+	var buffer bytes.Buffer
+	gack.Stdout = &buffer
+	*showDiff = true
+
+	filename := filepath.Join(dir, "gack.yml")
+	content := "package_name: foo\n"
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+
+	var mux *gack.Mux
+	if err == nil {
+		mux, err = gack.NewMuxFromFile(filename)
+	}
+
+	if err == nil {
+		Register(mux)
+		err = mux.Execute("generate")
+	}
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if data, _ := ioutil.ReadFile(filename); string(data) != content {
+		t.Errorf("Expected the file to be unchanged but got %q", data)
+	}
+
+	if !strings.Contains(buffer.String(), "+version:") {
+		t.Errorf("Expected the diff to add version but got %q", buffer.String())
+	}
+}
@@ -0,0 +1,168 @@
+package gack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source describes the file and position where an effective config
+// value was defined
+type Source struct {
+	Key    string
+	File   string
+	Line   int
+	Column int
+}
+
+func (s Source) String() string {
+	if s.File == "" {
+		return fmt.Sprintf("%d:%d", s.Line, s.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Column)
+}
+
+// configLoader reads a config file and every file it includes, keeping
+// track of which file each yaml node was read from
+type configLoader struct {
+	files   map[*yaml.Node]string
+	loading map[string]bool
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		files:   make(map[*yaml.Node]string),
+		loading: make(map[string]bool),
+	}
+}
+
+// load reads filename and returns its content merged over the content of
+// the files it includes.  Includes are listed under the "include" or
+// "extends" keys and are merged in order, so later includes override
+// earlier ones and the including file overrides them all
+func (l *configLoader) load(filename string) (*yaml.Node, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.loading[path] {
+		return nil, fmt.Errorf("Config file %s includes itself", filename)
+	}
+	l.loading[path] = true
+	defer delete(l.loading, path)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := parseConfig(filename, data)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	l.record(node, filename)
+
+	node, includes := removeIncludes(node)
+
+	var merged *yaml.Node
+	for _, include := range includes {
+		var included *yaml.Node
+		if include, err = resolveInclude(filepath.Dir(filename), include); err == nil {
+			included, err = l.load(include)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if included != nil {
+			merged = mergeNodes(merged, included)
+		}
+	}
+	return mergeNodes(merged, node), nil
+}
+
+func (l *configLoader) record(node *yaml.Node, filename string) {
+	l.files[node] = filename
+	for _, child := range node.Content {
+		l.record(child, filename)
+	}
+}
+
+// removeIncludes returns a copy of node without the include and extends
+// keys, along with the files those keys listed
+func removeIncludes(node *yaml.Node) (*yaml.Node, []string) {
+	if node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+
+	includes := []string{}
+	stripped := *node
+	stripped.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value != "include" && key.Value != "extends" {
+			stripped.Content = append(stripped.Content, key, value)
+		} else if value.Kind == yaml.SequenceNode {
+			for _, item := range value.Content {
+				includes = append(includes, item.Value)
+			}
+		} else {
+			includes = append(includes, value.Value)
+		}
+	}
+	return &stripped, includes
+}
+
+// resolveInclude finds an included file relative to dir.  If the file is
+// not in dir then each parent directory is searched, stopping at the
+// root of the repository
+func resolveInclude(dir, include string) (string, error) {
+	if filepath.IsAbs(include) {
+		return include, nil
+	}
+
+	for {
+		path := filepath.Join(dir, include)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return "", fmt.Errorf("Included config file %s not found", include)
+}
+
+// Sources returns the file and position of each value in the config
+func (c *Config) Sources() []Source {
+	sources := []Source{}
+	if c.node != nil {
+		sources = c.sources(sources, nil, c.node)
+	}
+	return sources
+}
+
+func (c *Config) sources(sources []Source, path []string, node *yaml.Node) []Source {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			sources = c.sources(sources, append(path, node.Content[i].Value), node.Content[i+1])
+		}
+		return sources
+	}
+
+	return append(sources, Source{
+		Key:    strings.Join(path, "."),
+		File:   c.files[node],
+		Line:   node.Line,
+		Column: node.Column,
+	})
+}
@@ -0,0 +1,75 @@
+package gack
+
+import (
+	"sort"
+)
+
+// indexNode is a node in a radix tree keyed by the literal prefix of
+// each registered pattern.  A pattern can only match a subject if its
+// literal prefix is also a prefix of the subject, so walking the tree
+// along the subject yields the (usually very small) set of patterns
+// that need to be run through the full matcher
+type indexNode struct {
+	edge     string
+	children map[byte]*indexNode
+	targets  []*Target
+}
+
+func newIndexNode(edge string) *indexNode {
+	return &indexNode{
+		edge:     edge,
+		children: make(map[byte]*indexNode),
+	}
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for ; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+	}
+	return i
+}
+
+func (n *indexNode) insert(key string, target *Target) {
+	for key != "" {
+		child := n.children[key[0]]
+		if child == nil {
+			child = newIndexNode(key)
+			n.children[key[0]] = child
+			n = child
+			break
+		}
+
+		common := commonPrefix(key, child.edge)
+		if common < len(child.edge) {
+			split := newIndexNode(child.edge[:common])
+			child.edge = child.edge[common:]
+			split.children[child.edge[0]] = child
+			n.children[key[0]] = split
+			child = split
+		}
+		n = child
+		key = key[common:]
+	}
+	n.targets = append(n.targets, target)
+}
+
+// lookup returns every target whose pattern prefix is a prefix of subject.
+// The targets are ordered the same way as Mux.TargetNames so that the first
+// matching target is the same one a linear scan would find
+func (n *indexNode) lookup(subject string) []*Target {
+	candidates := append([]*Target(nil), n.targets...)
+	for subject != "" {
+		child := n.children[subject[0]]
+		if child == nil || len(subject) < len(child.edge) || subject[:len(child.edge)] != child.edge {
+			break
+		}
+		candidates = append(candidates, child.targets...)
+		subject = subject[len(child.edge):]
+		n = child
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].pattern.pattern > candidates[j].pattern.pattern
+	})
+	return candidates
+}
@@ -0,0 +1,137 @@
+package gack
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFile is where the artifacts built by gack are recorded.  Nothing
+// is recorded when it is empty
+var ManifestFile = filepath.Join("dist", "manifest.json")
+
+// Kinds of artifact
+const (
+	ArtifactBinary  = "binary"
+	ArtifactDeb     = "deb"
+	ArtifactArchive = "archive"
+)
+
+// Artifact is a file produced by a target
+type Artifact struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Target    string `json:"target"`
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+// Manifest lists the artifacts that have been built, sorted by path
+type Manifest struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// ReadManifest reads the manifest in filename
+func ReadManifest(filename string) (*Manifest, error) {
+	manifest := &Manifest{}
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(data, manifest)
+	}
+	return manifest, err
+}
+
+// Artifact returns the artifact recorded for path
+func (m *Manifest) Artifact(path string) (Artifact, bool) {
+	for _, artifact := range m.Artifacts {
+		if artifact.Path == path {
+			return artifact, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// Add adds artifact to the manifest, replacing any artifact with the same path
+func (m *Manifest) Add(artifact Artifact) {
+	i := sort.Search(len(m.Artifacts), func(i int) bool { return m.Artifacts[i].Path >= artifact.Path })
+	if i < len(m.Artifacts) && m.Artifacts[i].Path == artifact.Path {
+		m.Artifacts[i] = artifact
+	} else {
+		m.Artifacts = append(m.Artifacts, Artifact{})
+		copy(m.Artifacts[i+1:], m.Artifacts[i:])
+		m.Artifacts[i] = artifact
+	}
+}
+
+// prune removes artifacts whose files no longer exist, such as those
+// removed by a clean
+func (m *Manifest) prune() {
+	artifacts := m.Artifacts[:0]
+	for _, artifact := range m.Artifacts {
+		if _, err := os.Stat(artifact.Path); err == nil {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	m.Artifacts = artifacts
+}
+
+// Write writes the manifest to filename.  The file is replaced in one step
+// so that readers never see a partial manifest
+func (m *Manifest) Write(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(filename+".tmp", append(data, '\n'), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(filename+".tmp", filename)
+	}
+	return err
+}
+
+// RecordArtifact adds an artifact to the ManifestFile.  The size and hash
+// are filled in from the artifact's file
+func RecordArtifact(artifact Artifact) error {
+	if ManifestFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(artifact.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if artifact.Size, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+	artifact.SHA256 = fmt.Sprintf("%x", hash.Sum(nil))
+
+	manifest, err := ReadManifest(ManifestFile)
+	if os.IsNotExist(err) {
+		manifest, err = &Manifest{}, nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %v", ManifestFile, err)
+	}
+
+	manifest.prune()
+	manifest.Add(artifact)
+	return manifest.Write(ManifestFile)
+}
@@ -0,0 +1,56 @@
+package gack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifestFile := ManifestFile
+	defer func() { ManifestFile = manifestFile }()
+	ManifestFile = filepath.Join(dir, "dist", "manifest.json")
+
+	for _, name := range []string{"b", "a", "c"} {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(name), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if err := RecordArtifact(Artifact{Path: filename, Kind: ArtifactBinary, Target: "build/" + name}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// replacing an artifact and removing another
+	os.Remove(filepath.Join(dir, "c"))
+	if err := RecordArtifact(Artifact{Path: filepath.Join(dir, "a"), Kind: ArtifactDeb, Target: "pkg/a"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	manifest, err := ReadManifest(ManifestFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Artifact{
+		{Path: filepath.Join(dir, "a"), Kind: ArtifactDeb, Size: 1, SHA256: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", Target: "pkg/a"},
+		{Path: filepath.Join(dir, "b"), Kind: ArtifactBinary, Size: 1, SHA256: "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d", Target: "build/b"},
+	}
+
+	if !reflect.DeepEqual(expected, manifest.Artifacts) {
+		t.Errorf("Expected artifacts %+v but got %+v", expected, manifest.Artifacts)
+	}
+
+	if artifact, found := manifest.Artifact(filepath.Join(dir, "b")); !found || artifact.Target != "build/b" {
+		t.Errorf("Expected to find artifact b but got %+v", artifact)
+	}
+}
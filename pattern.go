@@ -28,31 +28,64 @@ func (m *Match) Param(name string) string {
 	return m.captures[name]
 }
 
-// Interpolate a subject string with the captured values from a pattern
+// LookupParam will return the named parameter from the matched string
+// and whether or not the parameter was captured.  Parameters within an
+// optional segment that was not present in the subject are not captured
+func (m *Match) LookupParam(name string) (string, bool) {
+	value, found := m.captures[name]
+	return value, found
+}
+
+// Interpolate a subject string with the captured values from a pattern.
+// Optional segments in the subject are only included when all of their
+// captures are present
 func (m *Match) Interpolate(subject string) string {
 	var buffer bytes.Buffer
 	p := NewPattern(subject)
-	for _, token := range p.tokens {
-		if strings.HasPrefix(token, ":") {
+	m.interpolate(&buffer, p.tokens, false)
+	return buffer.String()
+}
+
+// interpolate writes tokens to buffer until the end of the current optional
+// segment.  It returns the remaining tokens and whether every capture in the
+// segment was found
+func (m *Match) interpolate(buffer *bytes.Buffer, tokens []string, nested bool) ([]string, bool) {
+	complete := true
+	for len(tokens) > 0 {
+		token := tokens[0]
+		tokens = tokens[1:]
+		if token == "]" && nested {
+			break
+		} else if token == "[" {
+			var segment bytes.Buffer
+			var present bool
+			tokens, present = m.interpolate(&segment, tokens, true)
+			if present {
+				buffer.Write(segment.Bytes())
+			}
+		} else if strings.HasPrefix(token, ":") {
 			token = token[1:]
 			str := "(MISSING)"
 			if s, found := m.captures[token]; found {
 				str = s
+			} else {
+				complete = false
 			}
 			buffer.WriteString(str)
 		} else {
 			buffer.WriteString(token)
 		}
 	}
-	return buffer.String()
+	return tokens, complete
 }
 
 // Pattern to match for a target. Patterns are strings optionally
 // containing WILDCARD characters in positions where the value is
 // not known ahead of time
 type Pattern struct {
-	pattern string
-	tokens  []string
+	pattern  string
+	tokens   []string
+	variants [][]string
 }
 
 // NewPattern creates a new pattern from a pattern string.  Patterns
@@ -87,9 +120,58 @@ func NewPattern(pattern string) Pattern {
 	l := newLexer(pattern, p.readTextState)
 	l.parse()
 	p.tokens = l.tokens
+	p.variants, _ = expandTokens(p.tokens, false)
 	return p
 }
 
+// expandTokens returns every combination of the optional segments in tokens
+// up to the end of the current segment, along with the tokens following the
+// segment.  Combinations including an optional segment are ordered before
+// those that omit it.  Unbalanced closing brackets are treated as text
+func expandTokens(tokens []string, nested bool) (variants [][]string, rest []string) {
+	variants = [][]string{nil}
+	for len(tokens) > 0 {
+		token := tokens[0]
+		tokens = tokens[1:]
+		if token == "]" && nested {
+			break
+		}
+
+		segments := [][]string{{token}}
+		if token == "[" {
+			segments, tokens = expandTokens(tokens, true)
+		}
+
+		next := [][]string{}
+		for _, variant := range variants {
+			for _, segment := range segments {
+				next = append(next, appendTokens(variant, segment))
+			}
+			if token == "[" {
+				next = append(next, variant)
+			}
+		}
+		variants = next
+	}
+	return variants, tokens
+}
+
+// appendTokens returns a new slice with the tokens of segment appended to
+// variant.  Adjacent literal tokens are joined so that captures are
+// delimited by all of the text that follows them
+func appendTokens(variant, segment []string) []string {
+	tokens := append([]string(nil), variant...)
+	for _, token := range segment {
+		last := len(tokens) - 1
+		if last >= 0 && !strings.HasPrefix(tokens[last], ":") && !strings.HasPrefix(token, ":") {
+			tokens[last] += token
+		} else {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
 func (p *Pattern) finishedState(l *lexer) stateFn {
 	l.appendToken()
 	return nil
@@ -116,40 +198,69 @@ func (p *Pattern) readTextState(l *lexer) stateFn {
 		l.appendToken()
 		l.next()
 		return p.readCaptureState
+	} else if r == '[' || r == ']' {
+		l.backup()
+		l.appendToken()
+		l.next()
+		l.appendToken()
 	}
 	return p.readTextState
 }
 
-// Match a subject string against the Pattern
+// prefix returns the literal text at the start of the pattern, before
+// the first capture
+func (p *Pattern) prefix() string {
+	if len(p.tokens) > 0 && !strings.HasPrefix(p.tokens[0], ":") && p.tokens[0] != "[" {
+		return p.tokens[0]
+	}
+	return ""
+}
+
+// Match a subject string against the Pattern.  The first variant includes
+// every optional segment, the others omit some of them and only match up
+// to the end of the subject or a /, so that verify[/:dir] does not match
+// verify-reproducible
 func (p *Pattern) Match(subject string) Match {
 	var match Match
 	match.subject = subject
-	match.captures = make(map[string]string)
-	match.match = true
-	for i, token := range p.tokens {
+	for i, tokens := range p.variants {
+		var rest string
+		match.captures, rest, match.match = matchTokens(tokens, subject)
+		if match.match && i > 0 && rest != "" && rest[0] != '/' {
+			match.match = false
+		}
+
+		if match.match {
+			match.captures["*"] = match.subject
+			break
+		}
+	}
+	return match
+}
+
+// matchTokens matches tokens against the start of subject and returns the
+// captures and the rest of the subject
+func matchTokens(tokens []string, subject string) (map[string]string, string, bool) {
+	captures := make(map[string]string)
+	for i, token := range tokens {
 		if strings.HasPrefix(token, ":") {
 			// remove the colon from the name
 			token = token[1:]
-			if i == len(p.tokens)-1 {
-				match.captures[token] = subject
+			if i == len(tokens)-1 {
+				captures[token] = subject
+				subject = ""
 				break
-			} else if index := strings.Index(subject, p.tokens[i+1]); index > -1 {
-				match.captures[token] = subject[0:index]
+			} else if index := strings.Index(subject, tokens[i+1]); index > -1 {
+				captures[token] = subject[0:index]
 				subject = subject[index:]
 			} else {
-				match.match = false
-				break
+				return nil, "", false
 			}
 		} else if strings.HasPrefix(subject, token) {
 			subject = subject[len(token):]
 		} else {
-			match.match = false
-			break
+			return nil, "", false
 		}
 	}
-
-	if match.match {
-		match.captures["*"] = match.subject
-	}
-	return match
+	return captures, subject, true
 }
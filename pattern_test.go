@@ -1,6 +1,7 @@
 package gack
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -17,6 +18,12 @@ func TestPattern(t *testing.T) {
 		{"build", "pkg", false, map[string]string{}},
 		{"build/foo-:foo", "build/foo-arch.ext", true, map[string]string{"foo": "arch.ext"}},
 		{"build/foo-:foo-bar", "build/foo-arch.ext-bar", true, map[string]string{"foo": "arch.ext"}},
+		{"pkg/deb/:package_:version[-:revision]_:arch.deb", "pkg/deb/foo_1.0-2_amd64.deb", true, map[string]string{"package": "foo", "version": "1.0", "revision": "2", "arch": "amd64"}},
+		{"pkg/deb/:package_:version[-:revision]_:arch.deb", "pkg/deb/foo_1.0_amd64.deb", true, map[string]string{"package": "foo", "version": "1.0", "arch": "amd64"}},
+		{"pkg/deb/:package_:version[-:revision]_:arch.deb", "pkg/deb/foo_1.0-2.deb", false, map[string]string{}},
+		{"build[/:os[-:arch]]", "build", true, map[string]string{}},
+		{"build[/:os[-:arch]]", "build/linux", true, map[string]string{"os": "linux"}},
+		{"build[/:os[-:arch]]", "build/linux-arm", true, map[string]string{"os": "linux", "arch": "arm"}},
 	}
 
 	for i, test := range tests {
@@ -48,3 +55,127 @@ func TestPattern(t *testing.T) {
 		}
 	}
 }
+
+func TestOptionalPattern(t *testing.T) {
+	pattern := NewPattern("pkg/deb/:package_:version[-:revision]_:arch.deb")
+	match := pattern.Match("pkg/deb/foo_1.0_amd64.deb")
+	if value, found := match.LookupParam("revision"); found {
+		t.Errorf("Expected revision to be absent but got %q", value)
+	}
+
+	if value, found := match.LookupParam("version"); !found || value != "1.0" {
+		t.Errorf("Expected version 1.0 but got %q", value)
+	}
+
+	tests := []struct {
+		subject  string
+		expected string
+	}{
+		{"build/:package[-:revision]", "build/foo"},
+		{"build/:package[-:version]", "build/foo-1.0"},
+		{"build/:package-:revision", "build/foo-(MISSING)"},
+		{"build/]:package", "build/]foo"},
+	}
+
+	for i, test := range tests {
+		if str := match.Interpolate(test.subject); str != test.expected {
+			t.Errorf("Test %d: Expected %s but got %s", i, test.expected, str)
+		}
+	}
+
+	// an omitted optional segment must not let verify match the start of
+	// verify-reproducible
+	mux, _ := NewMux()
+	called := ""
+	mux.Register("verify[/:dir]", ExecuteFunc(func(ctx *Context) error { called = "verify " + ctx.Param("dir"); return nil }))
+	mux.Register("verify-reproducible/:target", ExecuteFunc(func(ctx *Context) error { called = "verify-reproducible " + ctx.Param("target"); return nil }))
+
+	subjects := map[string]string{
+		"verify":                    "verify ",
+		"verify/dist":               "verify dist",
+		"verify-reproducible/build": "verify-reproducible build",
+	}
+
+	for subject, expected := range subjects {
+		called = ""
+		if err := mux.Execute(subject); err != nil {
+			t.Errorf("%s: Unexpected error: %v", subject, err)
+		} else if called != expected {
+			t.Errorf("%s: Expected %q to run but got %q", subject, expected, called)
+		}
+	}
+
+	pattern = NewPattern("verify[/:dir]")
+	if match := pattern.Match("verifyx"); match.Matches() {
+		t.Errorf("Expected verify[/:dir] to not match verifyx")
+	}
+}
+
+// linearLookup is the original Mux.Lookup implementation, kept to
+// compare the indexed lookup against
+func linearLookup(mux *Mux, input string) (*Target, []string, *Match) {
+	for _, targetName := range mux.targetNames {
+		target := mux.targets[targetName]
+		if match := target.pattern.Match(input); match.Matches() {
+			return target, target.dependencies, &match
+		}
+	}
+	return nil, nil, nil
+}
+
+func newLookupMux(n int) (*Mux, []string) {
+	mux := &Mux{
+		targets: make(map[string]*Target),
+		index:   newIndexNode(""),
+	}
+	mux.Register(":everything", nil)
+	mux.Register("build", nil)
+	mux.Register("build/:package_:platform_:architecture", nil)
+	mux.Register("pkg/deb/:package_:version_:architecture.deb", nil)
+
+	subjects := []string{}
+	for i := 0; i < n; i++ {
+		build := fmt.Sprintf("build/package%d_linux_amd64", i)
+		deb := fmt.Sprintf("pkg/deb/package%d_1.0_amd64.deb", i)
+		mux.Register(build, nil)
+		mux.Register(deb, nil)
+		subjects = append(subjects, build, deb, fmt.Sprintf("file%d", i))
+	}
+	return mux, subjects
+}
+
+// targetPattern returns the pattern of target, which may be nil
+func targetPattern(target *Target) string {
+	if target == nil {
+		return "<nil>"
+	}
+	return target.pattern.pattern
+}
+
+func TestLookupIndex(t *testing.T) {
+	mux, subjects := newLookupMux(100)
+	subjects = append(subjects, "", "build", "build/", "pkg", "pkg/deb/foo.deb")
+	for _, subject := range subjects {
+		expected, _, _ := linearLookup(mux, subject)
+		got, _, _ := mux.Lookup(subject)
+		if expected != got {
+			t.Errorf("%q: Expected target %s but got %s", subject, targetPattern(expected), targetPattern(got))
+		}
+	}
+}
+
+func benchmarkLookup(b *testing.B, lookup func(*Mux, string) (*Target, []string, *Match)) {
+	mux, subjects := newLookupMux(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup(mux, subjects[i%len(subjects)])
+	}
+}
+
+func BenchmarkLookupLinear(b *testing.B) {
+	benchmarkLookup(b, linearLookup)
+}
+
+func BenchmarkLookupIndexed(b *testing.B) {
+	benchmarkLookup(b, (*Mux).Lookup)
+}
@@ -3,9 +3,12 @@ package pkg
 import (
 	"fmt"
 	"os"
+	"path"
+	"strings"
 
 	"github.com/abates/gack"
 	"github.com/abates/gack/build"
+	"github.com/abates/gack/sbom"
 	"github.com/xor-gate/debpkg"
 )
 
@@ -20,17 +23,36 @@ func NewDebPackager(mux *gack.Mux) *debPackager {
 }
 
 func (p *debPackager) clean(context *gack.Context) error {
-	fmt.Printf("Cleaning pkg/deb/*\n")
+	gack.Logf("Cleaning pkg/deb/*\n")
 	return os.RemoveAll("pkg/deb/")
 }
 
+// debTarget packages the binaries bundled in one of the project's packages
+type debTarget struct {
+	*debPackager
+	name     string
+	binaries []gack.Binary
+}
+
+func (d *debTarget) Execute(context *gack.Context) error {
+	return d.pack(context, d.name, d.binaries)
+}
+
 func (p *debPackager) Execute(context *gack.Context) error {
-	fmt.Printf("Packaging %s\n", context.Target.Subject())
+	binaries := p.config.Packages()[context.Param("package")]
+	if len(binaries) == 0 {
+		binaries = []gack.Binary{{Name: context.Param("package")}}
+	}
+	return p.pack(context, context.Param("package"), binaries)
+}
+
+func (p *debPackager) pack(context *gack.Context, name string, binaries []gack.Binary) error {
+	gack.Logf("Packaging %s\n", context.Target.Subject())
 	deb := debpkg.New()
 	defer deb.Close()
 
-	deb.SetName(p.config.PackageName)
-	deb.SetVersion(p.config.Version)
+	deb.SetName(name)
+	deb.SetVersion(p.config.PackageVersion())
 	deb.SetArchitecture(context.Param("architecture"))
 	deb.SetMaintainer(p.config.Maintainer)
 	deb.SetMaintainerEmail(p.config.MaintainerEmail)
@@ -39,31 +61,97 @@ func (p *debPackager) Execute(context *gack.Context) error {
 	deb.SetShortDescription(p.config.ShortDescription)
 	deb.SetDescription(p.config.Description)
 
-	err := os.MkdirAll("pkg/deb/", 0755)
+	sbomConfig, err := sbom.GetConfig(p.config)
+	var platform build.Platform
+	if err == nil {
+		platform, err = build.ParsePlatform("linux", context.Param("architecture"))
+	}
+
 	if err == nil {
-		deb.AddFile(fmt.Sprintf("build/%s", build.Name(p.config.PackageName, "linux", context.Param("architecture"))))
-		err = deb.Write(fmt.Sprintf("pkg/deb/%s_%s_%s.deb", p.config.PackageName, p.config.Version, context.Param("architecture")))
+		err = os.MkdirAll("pkg/deb/", 0755)
+	}
+
+	goVersion := ""
+	for _, binary := range binaries {
+		var artifact string
+		if err == nil {
+			artifact, err = build.ArtifactPath(p.config, binary, platform)
+		}
+
+		if err == nil {
+			err = deb.AddFile(artifact, path.Join("/usr/bin", binary.Name))
+		}
+
+		if err == nil && sbomConfig.Embed {
+			err = p.addSBOMs(deb, name, binary, artifact, sbomConfig.Formats)
+		}
+
+		if goVersion == "" {
+			goVersion = build.GoVersion(artifact)
+		}
+	}
+
+	filename := fmt.Sprintf("pkg/deb/%s_%s_%s.deb", name, p.config.PackageVersion(), context.Param("architecture"))
+	if err == nil {
+		err = deb.Write(filename)
+	}
+
+	if err == nil {
+		err = gack.RecordArtifact(gack.Artifact{
+			Path:      filename,
+			Kind:      gack.ArtifactDeb,
+			OS:        platform.OS,
+			Arch:      platform.Arch,
+			Variant:   platform.Variant,
+			Version:   p.config.PackageVersion(),
+			Target:    context.Target.Subject(),
+			GoVersion: goVersion,
+		})
+	}
+	return err
+}
+
+// addSBOMs generates the SBOMs for a binary and adds them to the package's
+// documentation
+func (p *debPackager) addSBOMs(deb *debpkg.DebPkg, name string, binary gack.Binary, artifact string, formats []string) error {
+	filenames, err := sbom.Write(artifact, p.config.Version, formats)
+	for _, filename := range filenames {
+		if err == nil {
+			err = deb.AddFile(filename, path.Join("/usr/share/doc", name, binary.Name+strings.TrimPrefix(filename, artifact)))
+		}
 	}
 	return err
 }
 
 func (p *debPackager) Register(mux *gack.Mux) error {
-	pkg := mux.Config.PackageName
-	for _, dependency := range build.Dependencies(mux) {
-		if dependency.Platform == "linux" {
-			mux.AddDependency("pkg/deb", fmt.Sprintf("pkg/deb/%s_%s_%s.deb", pkg, mux.Config.Version, dependency.Arch), nil)
+	debs := make(map[string]bool)
+	dependencies, err := build.Dependencies(mux)
+	for _, dependency := range dependencies {
+		deb := fmt.Sprintf("pkg/deb/%s_%s_%s.deb", dependency.Binary.Package, mux.Config.PackageVersion(), dependency.Platform.ArchName())
+		if dependency.Platform.OS == "linux" && !debs[deb] {
+			mux.AddDependency("pkg/deb", deb, nil)
+			debs[deb] = true
+		}
+	}
+
+	for pkg, binaries := range mux.Config.Packages() {
+		builds := []string{}
+		for _, binary := range binaries {
+			builds = append(builds, fmt.Sprintf("build/%s_linux_:architecture", binary.Name))
 		}
+		mux.Register(fmt.Sprintf("pkg/deb/%s_:version_:architecture.deb", pkg), &debTarget{p, pkg, binaries}, builds...)
 	}
 
 	mux.Register("pkg/deb/:package_:version_:architecture.deb", p, "build/:package_linux_:architecture")
 	mux.AddDependency("clean/pkg", "clean/pkg/deb", gack.ExecuteFunc(p.clean))
-	return nil
+	return err
 }
 
-func Register(mux *gack.Mux) {
-	NewDebPackager(mux).Register(mux)
+func Register(mux *gack.Mux) error {
+	err := NewDebPackager(mux).Register(mux)
 	mux.AddDependency("clean", "clean/pkg", gack.ExecuteFunc(func(*gack.Context) error {
-		fmt.Printf("Cleaning pkg/*\n")
+		gack.Logf("Cleaning pkg/*\n")
 		return os.RemoveAll("pkg/")
 	}))
+	return err
 }
@@ -0,0 +1,284 @@
+package gack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOption documents a single config key.  Options are described by
+// the desc struct tag on config fields, and the allowed struct tag can
+// list the values a field accepts, separated by commas
+type ConfigOption struct {
+	Key         string
+	Type        string
+	Default     string
+	Description string
+	Allowed     []string
+}
+
+// DescribeConfig returns an option for every documented key of the config
+// and its sections
+func DescribeConfig(defaults *Config) []ConfigOption {
+	options := describe(nil, nil, reflect.ValueOf(defaults))
+	names := []string{}
+	for name := range defaults.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		options = describe(options, []string{name}, reflect.ValueOf(defaults.Targets[name]))
+	}
+	return options
+}
+
+func describe(options []ConfigOption, path []string, value reflect.Value) []ConfigOption {
+	t := value.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if value.IsValid() {
+			value = value.Elem()
+		}
+	}
+
+	if t.Kind() != reflect.Struct {
+		return options
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		var fieldValue reflect.Value
+		if value.IsValid() {
+			fieldValue = value.Field(i)
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && strings.Contains(field.Tag.Get("yaml"), ",inline") {
+			if !fieldValue.IsValid() {
+				fieldValue = reflect.Zero(field.Type)
+			}
+			options = describe(options, path, fieldValue)
+			continue
+		}
+
+		name := keyName(field)
+		if name == "" {
+			continue
+		}
+
+		key := append(append([]string(nil), path...), name)
+		option := ConfigOption{
+			Key:         strings.Join(key, "."),
+			Type:        typeName(field.Type),
+			Description: field.Tag.Get("desc"),
+		}
+
+		if allowed := field.Tag.Get("allowed"); allowed != "" {
+			option.Allowed = strings.Split(allowed, ",")
+		}
+
+		// the defaults of structs are shown with each of their fields
+		if fieldValue.IsValid() && !fieldValue.IsZero() && field.Type.Kind() != reflect.Struct {
+			option.Default = formatValue(fieldValue.Interface())
+		}
+		options = append(options, option)
+
+		// describe the fields of structs, and of the structs in lists
+		elem := field.Type
+		if elem.Kind() == reflect.Slice {
+			elem = elem.Elem()
+			key[len(key)-1] += "[]"
+			fieldValue = reflect.Value{}
+		}
+
+		if elem.Kind() == reflect.Struct || (elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct) {
+			if !fieldValue.IsValid() {
+				fieldValue = reflect.Zero(elem)
+			}
+			options = describe(options, key, fieldValue)
+		}
+	}
+	return options
+}
+
+// keyName returns the yaml key for a struct field, or an empty string if the
+// field is not written to yaml on its own
+func keyName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	if field.PkgPath != "" || tag == "-" || strings.Contains(tag, ",inline") {
+		return ""
+	}
+
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeName(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return "list of " + typeName(t.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map of %s to %s", typeName(t.Key()), typeName(t.Elem()))
+	case reflect.Struct:
+		return "mapping"
+	case reflect.Interface:
+		return "any"
+	}
+	return t.Kind().String()
+}
+
+func formatValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+func (o ConfigOption) comment() string {
+	lines := []string{}
+	if o.Description != "" {
+		lines = append(lines, o.Description)
+	}
+
+	details := "type: " + o.Type
+	if o.Default != "" {
+		details += fmt.Sprintf(", default: %s", o.Default)
+	}
+
+	if len(o.Allowed) > 0 {
+		details += fmt.Sprintf(", allowed: %s", strings.Join(o.Allowed, ", "))
+	}
+	return strings.Join(append(lines, details), "\n")
+}
+
+// commentNode adds the description of each option as a comment above its key
+// in node
+func commentNode(node *yaml.Node, options []ConfigOption) {
+	comments := make(map[string]string)
+	for _, option := range options {
+		comments[option.Key] = option.comment()
+	}
+
+	var walk func(path []string, node *yaml.Node)
+	walk = func(path []string, node *yaml.Node) {
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := append(path, node.Content[i].Value)
+			if comment, found := comments[strings.Join(key, ".")]; found {
+				node.Content[i].HeadComment = comment
+			}
+			walk(key, node.Content[i+1])
+		}
+	}
+	walk(nil, node)
+}
+
+// commentMissing adds the options that are not in node, such as the
+// fields that are omitted when empty, as commented out entries at the end
+// of the mapping they belong in.  Entries that are already commented out
+// are not added again
+func commentMissing(node *yaml.Node, options []ConfigOption) {
+	var walk func(path string, node *yaml.Node)
+	walk = func(path string, node *yaml.Node) {
+		if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
+			return
+		}
+
+		present := make(map[string]bool)
+		comments := []string{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			present[key.Value] = true
+			comments = append(comments, key.HeadComment, key.LineComment, key.FootComment, value.FootComment)
+			walk(strings.TrimPrefix(path+"."+key.Value, "."), value)
+		}
+		commented := strings.Join(comments, "\n")
+
+		entries := []string{}
+		for i, option := range options {
+			name := strings.TrimPrefix(option.Key, path+".")
+			if path == "" {
+				name = option.Key
+			}
+
+			if name == option.Key && path != "" || strings.ContainsAny(name, ".[") || present[name] || strings.Contains(commented, "# "+name+":") {
+				continue
+			}
+			entries = append(entries, commentedEntry(name, option, options[i+1:]))
+		}
+
+		if len(entries) > 0 {
+			last := node.Content[len(node.Content)-2]
+			last.FootComment = strings.TrimPrefix(last.FootComment+"\n"+strings.Join(entries, "\n"), "\n")
+		}
+	}
+	walk("", node)
+}
+
+// commentedEntry returns option as a commented out yaml entry.  The fields
+// of lists of mappings, which follow the option, are shown as an example
+// item
+func commentedEntry(name string, option ConfigOption, following []ConfigOption) string {
+	lines := []string{}
+	for _, line := range strings.Split(option.comment(), "\n") {
+		lines = append(lines, "# "+line)
+	}
+
+	entry := "# " + name + ":"
+	if option.Default != "" {
+		entry += " " + option.Default
+	}
+	lines = append(lines, entry)
+
+	indent := "#   - "
+	for _, field := range following {
+		if !strings.HasPrefix(field.Key, option.Key+"[].") {
+			break
+		}
+
+		fieldName := strings.TrimPrefix(field.Key, option.Key+"[].")
+		if strings.ContainsAny(fieldName, ".[") {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s%s: # %s", indent, fieldName, field.Description))
+		indent = "#     "
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WriteConfigReference writes a Markdown page documenting every option of
+// the config and its sections
+func WriteConfigReference(writer io.Writer, defaults *Config) error {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+	_, err := fmt.Fprintf(writer, "# Configuration reference\n\n| Key | Type | Default | Allowed | Description |\n| --- | --- | --- | --- | --- |\n")
+	for _, option := range DescribeConfig(defaults) {
+		if err != nil {
+			break
+		}
+
+		defaultValue := ""
+		if option.Default != "" {
+			defaultValue = fmt.Sprintf("`%s`", option.Default)
+		}
+
+		_, err = fmt.Fprintf(writer, "| `%s` | %s | %s | %s | %s |\n", option.Key, option.Type, escape.Replace(defaultValue), strings.Join(option.Allowed, ", "), escape.Replace(option.Description))
+	}
+	return err
+}
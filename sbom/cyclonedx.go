@@ -0,0 +1,100 @@
+package sbom
+
+import (
+	"time"
+)
+
+// the subset of the CycloneDX 1.5 JSON format that gack writes
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	Purl       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxTool struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type cdxMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []cdxTool `json:"components"`
+	} `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+func cycloneDX(b *binary) interface{} {
+	bom := &cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Components:  []cdxComponent{},
+	}
+
+	main := purl(b.info.Main.Path, b.version)
+	bom.Metadata.Timestamp = b.created.Format(time.RFC3339)
+	bom.Metadata.Tools.Components = []cdxTool{{Type: "application", Name: "gack"}}
+	bom.Metadata.Component = cdxComponent{
+		Type:    "application",
+		BOMRef:  main,
+		Name:    b.name,
+		Version: b.version,
+		Purl:    main,
+		Hashes:  []cdxHash{{"SHA-256", b.sha256}},
+	}
+
+	dependsOn := []string{}
+	for _, dep := range b.dependencies() {
+		component := cdxComponent{
+			Type:    "library",
+			BOMRef:  purl(dep.Path, dep.Version),
+			Name:    dep.Path,
+			Version: dep.Version,
+			Purl:    purl(dep.Path, dep.Version),
+		}
+
+		// the go.sum hash is a hash of the module's files rather than of
+		// any file that can be downloaded, so it is not one of the hashes
+		if dep.Sum != "" {
+			component.Properties = []cdxProperty{{"gack:go.sum", dep.Sum}}
+		}
+		bom.Components = append(bom.Components, component)
+		dependsOn = append(dependsOn, component.BOMRef)
+	}
+
+	bom.Dependencies = []cdxDependency{{Ref: main, DependsOn: dependsOn}}
+	for _, ref := range dependsOn {
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: ref})
+	}
+	return bom
+}
@@ -0,0 +1,209 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abates/gack"
+	"github.com/abates/gack/build"
+)
+
+type Config struct {
+	Formats []string `yaml:"formats" config_name:"formats" desc:"SBOM formats written next to each binary" allowed:"cyclonedx,spdx"`
+	Embed   bool     `yaml:"embed" config_name:"embed" desc:"Include the SBOMs of a package's binaries in its deb under /usr/share/doc/<package>"`
+}
+
+func DefaultConfig() (string, *Config) {
+	return "sbom", &Config{
+		Formats: []string{"cyclonedx", "spdx"},
+	}
+}
+
+// GetConfig returns the sbom section of the config
+func GetConfig(config *gack.Config) (*Config, error) {
+	name, sbomConfig := DefaultConfig()
+	// lists are decoded over the default element by element, so the default
+	// formats are only used when none are configured
+	formats := sbomConfig.Formats
+	sbomConfig.Formats = nil
+	err := config.Get(name, sbomConfig)
+	if err == gack.ErrConfigKeyNotFound {
+		err = nil
+	}
+
+	if len(sbomConfig.Formats) == 0 {
+		sbomConfig.Formats = formats
+	}
+	return sbomConfig, err
+}
+
+// encoders write the SBOM formats, keyed by name
+var encoders = map[string]struct {
+	ext    string
+	encode func(*binary) interface{}
+}{
+	"cyclonedx": {".cdx.json", cycloneDX},
+	"spdx":      {".spdx.json", spdx},
+}
+
+// Ext returns the extension of the SBOM files written in format
+func Ext(format string) (string, error) {
+	encoder, found := encoders[format]
+	if !found {
+		return "", fmt.Errorf("Unknown SBOM format %q, expected cyclonedx or spdx", format)
+	}
+	return encoder.ext, nil
+}
+
+// binary is what is known about a go binary from its build info
+type binary struct {
+	name    string
+	version string
+	sha256  string
+	created time.Time
+	info    *buildinfo.BuildInfo
+}
+
+func readBinary(filename, version string) (*binary, error) {
+	info, err := buildinfo.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &binary{
+		name:    filepath.Base(filename),
+		version: info.Main.Version,
+		sha256:  fmt.Sprintf("%x", sha256.Sum256(data)),
+		info:    info,
+	}
+
+	if b.version == "" || b.version == "(devel)" {
+		b.version = version
+	}
+
+	// the SBOM is dated by the build rather than when it was generated so
+	// that reproducible builds have reproducible SBOMs
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		b.created = time.Unix(epoch, 0)
+	} else if vcsTime, err := time.Parse(time.RFC3339, setting(info, "vcs.time")); err == nil {
+		b.created = vcsTime
+	} else if stat, err := os.Stat(filename); err == nil {
+		b.created = stat.ModTime()
+	}
+	b.created = b.created.UTC().Truncate(time.Second)
+	return b, nil
+}
+
+func setting(info *buildinfo.BuildInfo, key string) string {
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// dependencies returns the modules linked into the binary, with replaced
+// modules swapped for their replacements.  Replacements with a local
+// directory don't have a version, so the original module is listed
+func (b *binary) dependencies() []*debug.Module {
+	modules := []*debug.Module{}
+	for _, dep := range b.info.Deps {
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			dep = dep.Replace
+		}
+		modules = append(modules, dep)
+	}
+
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules
+}
+
+func purl(path, version string) string {
+	return fmt.Sprintf("pkg:golang/%s@%s", path, version)
+}
+
+// Write writes the SBOMs for the binary in filename next to it and returns
+// their file names.  version is used when the binary doesn't have a module
+// version of its own
+func Write(filename, version string, formats []string) ([]string, error) {
+	b, err := readBinary(filename, version)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := []string{}
+	for _, format := range formats {
+		ext, err := Ext(format)
+		var data []byte
+		if err == nil {
+			data, err = json.MarshalIndent(encoders[format].encode(b), "", "  ")
+		}
+
+		if err == nil {
+			err = ioutil.WriteFile(filename+ext, append(data, '\n'), 0644)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename+ext)
+	}
+	return filenames, nil
+}
+
+type generator struct{}
+
+func (g *generator) DefaultConfig() (string, interface{}) {
+	return DefaultConfig()
+}
+
+// Execute writes the SBOMs of every go binary in the build directory
+func (g *generator) Execute(ctx *gack.Context) error {
+	config, err := GetConfig(ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(build.OutputDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := filepath.Join(build.OutputDir, file.Name())
+		if file.IsDir() || strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		// only go binaries have build info
+		if _, err := buildinfo.ReadFile(filename); err != nil {
+			continue
+		}
+
+		gack.Logf("Writing SBOM for %s\n", filename)
+		if _, err = Write(filename, ctx.Config.Version, config.Formats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Register(mux *gack.Mux) {
+	mux.Register("sbom", &generator{}, "build")
+}
@@ -0,0 +1,97 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abates/gack"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func testBinary() *binary {
+	return &binary{
+		name:    "hello_linux_amd64",
+		version: "1.2.3",
+		sha256:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		info: &debug.BuildInfo{
+			GoVersion: "go1.21.0",
+			Main:      debug.Module{Path: "example.com/hello", Version: "(devel)"},
+			Deps: []*debug.Module{
+				{Path: "gopkg.in/yaml.v3", Version: "v3.0.1", Sum: "h1:fxVm/GzAzEWqLHuvctI91KS9hhNmmWOoWu0XTYJS7CA="},
+				{
+					Path:    "example.com/old",
+					Version: "v1.0.0",
+					Sum:     "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+					Replace: &debug.Module{Path: "example.com/new", Version: "v1.1.0", Sum: "h1:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB="},
+				},
+				{Path: "example.com/local", Version: "v0.1.0", Replace: &debug.Module{Path: "../local"}},
+			},
+		},
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	for _, format := range []string{"cyclonedx", "spdx"} {
+		ext, err := Ext(format)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		got, err := json.MarshalIndent(encoders[format].encode(testBinary()), "", "  ")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got = append(got, '\n')
+
+		golden := filepath.Join("testdata", "hello"+ext)
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(expected, got) {
+			t.Errorf("%s: Expected:\n%s\nGot:\n%s", format, expected, got)
+		}
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"package_name: hello\n", []string{"cyclonedx", "spdx"}},
+		{"package_name: hello\nsbom:\n  formats: [spdx]\n", []string{"spdx"}},
+	}
+
+	for _, test := range tests {
+		config := gack.NewConfig()
+		err := gack.ReadConfig(config, strings.NewReader(test.input))
+		var sbomConfig *Config
+		if err == nil {
+			sbomConfig, err = GetConfig(config)
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		} else if !reflect.DeepEqual(test.expected, sbomConfig.Formats) {
+			t.Errorf("Expected formats %v but got %v", test.expected, sbomConfig.Formats)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package sbom
+
+import (
+	"fmt"
+	"time"
+)
+
+// the subset of the SPDX 2.3 JSON format that gack writes
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment          string            `json:"comment,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+func spdxPurl(path, version string) []spdxExternalRef {
+	return []spdxExternalRef{{"PACKAGE-MANAGER", "purl", purl(path, version)}}
+}
+
+func spdx(b *binary) interface{} {
+	doc := &spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        b.name,
+		// the namespace has to be unique to the document, the hash of the
+		// binary keeps it unique while being the same for the same binary
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", b.name, b.sha256),
+	}
+	doc.CreationInfo.Created = b.created.Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: gack"}
+
+	doc.Packages = []spdxPackage{{
+		Name:             b.name,
+		SPDXID:           "SPDXRef-Package-main",
+		VersionInfo:      b.version,
+		DownloadLocation: "NOASSERTION",
+		Checksums:        []spdxChecksum{{"SHA256", b.sha256}},
+		ExternalRefs:     spdxPurl(b.info.Main.Path, b.version),
+	}}
+	doc.Relationships = []spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-main"}}
+
+	for i, dep := range b.dependencies() {
+		pkg := spdxPackage{
+			Name:             dep.Path,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i+1),
+			VersionInfo:      dep.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     spdxPurl(dep.Path, dep.Version),
+		}
+
+		// SPDX checksums are of downloaded files, which the go.sum hash is not
+		if dep.Sum != "" {
+			pkg.Comment = "go.sum hash " + dep.Sum
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-Package-main", "DEPENDS_ON", pkg.SPDXID})
+	}
+	return doc
+}
@@ -0,0 +1,188 @@
+package gack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/abates/gack/command"
+)
+
+// SecretPrefix starts config values that refer to a secret rather than
+// containing it.  Secrets can come from an environment variable, a file or
+// the output of a command:
+//
+//	secret://env/SIGNING_KEY
+//	secret://file/keys/signing.key
+//	secret://cmd/pass show "signing key"
+const SecretPrefix = "secret://"
+
+const redacted = "********"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// Stdout and Stderr mask every resolved secret in what is written to
+	// them.  All gack output should go through these
+	Stdout io.Writer = NewRedactor(os.Stdout)
+	Stderr io.Writer = NewRedactor(os.Stderr)
+)
+
+// IsSecret indicates whether value is a reference to a secret
+func IsSecret(value string) bool {
+	return strings.HasPrefix(value, SecretPrefix)
+}
+
+// ResolveSecret returns the secret that value refers to.  Values that are
+// not secret references are returned unchanged.  Secrets are only resolved
+// when this is called, so targets should resolve secrets right before they
+// need them.  Once resolved, a secret is masked in everything written to
+// Stdout, Stderr, or a writer returned by NewRedactor
+func ResolveSecret(value string) (string, error) {
+	if !IsSecret(value) {
+		return value, nil
+	}
+
+	reference := strings.TrimPrefix(value, SecretPrefix)
+	kind, name := reference, ""
+	if i := strings.Index(reference, "/"); i >= 0 {
+		kind, name = reference[:i], reference[i+1:]
+	}
+
+	var secret string
+	var err error
+	switch kind {
+	case "env":
+		var found bool
+		if secret, found = os.LookupEnv(name); !found {
+			err = fmt.Errorf("Environment variable %s is not set", name)
+		}
+	case "file":
+		var data []byte
+		data, err = ioutil.ReadFile(name)
+		secret = strings.TrimRight(string(data), "\r\n")
+	case "cmd":
+		// recorded calls are redacted when they are saved, by which time
+		// the output has been added to the secrets
+		var args []string
+		args, err = command.Split(name)
+		if err == nil && len(args) == 0 {
+			err = fmt.Errorf("Missing command")
+		}
+
+		if err == nil {
+			secret, err = command.Run(&command.Command{Name: args[0], Args: args[1:], Stderr: Stderr})
+			secret = strings.TrimRight(secret, "\r\n")
+		}
+	default:
+		err = fmt.Errorf("Unknown secret type %q", kind)
+	}
+
+	if err != nil {
+		// the reference itself is not secret
+		return "", fmt.Errorf("Failed to resolve %s: %v", value, err)
+	}
+
+	addSecret(secret)
+	return secret, nil
+}
+
+func addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact masks every resolved secret in str
+func Redact(str string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		str = strings.Replace(str, secret, redacted, -1)
+	}
+	return str
+}
+
+type redactor struct {
+	mu     sync.Mutex
+	writer io.Writer
+
+	// pending is the end of the previous writes that could be the start
+	// of a secret
+	pending []byte
+}
+
+// NewRedactor returns a writer that masks every resolved secret before
+// writing to writer.  Secrets split across writes are masked too, so text
+// that could be the start of a secret is held back until the next write
+func NewRedactor(writer io.Writer) io.Writer {
+	return &redactor{writer: writer}
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := []byte(Redact(string(r.pending) + string(p)))
+	hold := secretPrefix(data)
+	r.pending = append([]byte(nil), data[len(data)-hold:]...)
+	_, err := r.writer.Write(data[:len(data)-hold])
+	return len(p), err
+}
+
+// Flush writes the text that is being held back
+func (r *redactor) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.writer.Write(r.pending)
+	r.pending = nil
+	return err
+}
+
+// FlushOutput writes any text that Stdout and Stderr are holding back in
+// case it is the start of a secret.  It should be called before exiting
+func FlushOutput() {
+	for _, writer := range []io.Writer{Stdout, Stderr} {
+		if r, ok := writer.(*redactor); ok {
+			r.Flush()
+		}
+	}
+}
+
+// secretPrefix returns the length of the longest end of data that is the
+// start of a secret
+func secretPrefix(data []byte) int {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	longest := 0
+	for _, secret := range secrets {
+		for n := len(secret) - 1; n > longest; n-- {
+			if n <= len(data) && bytes.HasSuffix(data, []byte(secret[:n])) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// Logf writes a formatted message to Stdout
+func Logf(format string, args ...interface{}) {
+	fmt.Fprintf(Stdout, format, args...)
+}
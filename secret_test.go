@@ -0,0 +1,107 @@
+package gack
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/abates/gack/command"
+)
+
+func TestResolveSecret(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, "file-secret\n")
+	file.Close()
+
+	os.Setenv("GACK_TEST_SECRET", "env-secret")
+	defer os.Unsetenv("GACK_TEST_SECRET")
+
+	tests := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{"plain value", "plain value", false},
+		{"secret://env/GACK_TEST_SECRET", "env-secret", false},
+		{"secret://file/" + file.Name(), "file-secret", false},
+		{"secret://cmd/echo cmd-secret", "cmd-secret", false},
+		{`secret://cmd/sh -c "echo 'quoted secret'"`, "quoted secret", false},
+		{`secret://cmd/sh -c "echo 'unterminated`, "", true},
+		{"secret://env/GACK_TEST_MISSING", "", true},
+		{"secret://vault/foo", "", true},
+	}
+
+	for i, test := range tests {
+		secret, err := ResolveSecret(test.value)
+		if test.err && err == nil {
+			t.Errorf("Test %d: Expected an error", i)
+		} else if !test.err && err != nil {
+			t.Errorf("Test %d: Unexpected error: %v", i, err)
+		} else if secret != test.expected {
+			t.Errorf("Test %d: Expected %q but got %q", i, test.expected, secret)
+		}
+	}
+
+	var buffer bytes.Buffer
+	writer := NewRedactor(&buffer)
+	fmt.Fprintf(writer, "token=env-secret key=file-secret cmd=cmd-secret value=plain value")
+	writer.(*redactor).Flush()
+	expected := "token=******** key=******** cmd=******** value=plain value"
+	if buffer.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buffer.String())
+	}
+}
+
+func TestResolveSecretReplay(t *testing.T) {
+	runner := command.DefaultRunner
+	defer func() { command.DefaultRunner = runner }()
+
+	replay := command.NewReplay(command.Call{Name: "pass", Args: []string{"show", "my key"}, Output: "replayed-secret\n"})
+	command.DefaultRunner = replay
+
+	secret, err := ResolveSecret(`secret://cmd/pass show "my key"`)
+	if err != nil || secret != "replayed-secret" {
+		t.Errorf("Expected %q but got %q (%v)", "replayed-secret", secret, err)
+	}
+
+	if remaining := replay.Remaining(); len(remaining) > 0 {
+		t.Errorf("Expected %v to be run", remaining)
+	}
+}
+
+func TestRedactorSplitWrites(t *testing.T) {
+	addSecret("split-secret")
+	addSecret("secret-two")
+
+	tests := []struct {
+		writes   []string
+		expected string
+	}{
+		{[]string{"key=split-", "secret done"}, "key=******** done"},
+		{[]string{"key=s", "p", "l", "i", "t-secre", "t"}, "key=********"},
+		{[]string{"key=split-sec", "ond try"}, "key=split-second try"},
+		{[]string{"a split-secret-two", " b"}, "a ********-two b"},
+		{[]string{"end split-se"}, "end split-se"},
+	}
+
+	for _, test := range tests {
+		var buffer bytes.Buffer
+		writer := NewRedactor(&buffer)
+		for _, write := range test.writes {
+			if n, err := writer.Write([]byte(write)); n != len(write) || err != nil {
+				t.Errorf("%q: Expected %d bytes to be written but got %d, %v", test.writes, len(write), n, err)
+			}
+		}
+		writer.(*redactor).Flush()
+
+		if buffer.String() != test.expected {
+			t.Errorf("%q: Expected %q but got %q", test.writes, test.expected, buffer.String())
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package gack
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem found while validating a config along with
+// the position of the offending key or value
+type ConfigError struct {
+	Source
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Source, e.Message)
+}
+
+// ConfigErrors is every problem found while validating a config
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Validate strictly checks the config file against the top level config
+// fields and the given sections.  Sections map a section name to a value
+// of the type the section is decoded into.  Any key that is not a known
+// field or section, and any value that cannot be decoded into its field
+// is reported
+func (c *Config) Validate(sections map[string]interface{}) error {
+	return c.validate(sections, true)
+}
+
+func (c *Config) validate(sections map[string]interface{}, strict bool) error {
+	if c.node == nil {
+		return nil
+	}
+
+	v := &validator{config: c, sections: sections, strict: strict}
+	configType := reflect.TypeOf(c).Elem()
+	for i := 0; i+1 < len(c.node.Content); i += 2 {
+		key, value := c.node.Content[i], c.node.Content[i+1]
+		if field, found := fieldByKey(configType, key.Value, "yaml"); found {
+			v.validate([]string{key.Value}, value, field.Type, "yaml")
+		} else if section, found := sections[key.Value]; found && section != nil {
+			v.validate([]string{key.Value}, value, reflect.TypeOf(section), "config_name")
+		} else if strict {
+			v.errorf(nil, key, "Unknown key %q", key.Value)
+		}
+	}
+	return v.err()
+}
+
+// validateSection strictly checks a section of the config against the
+// type it is being decoded into
+func (c *Config) validateSection(name string, output interface{}) error {
+	v := &validator{config: c}
+	if node := lookupNode(c.node, name); node != nil {
+		v.validate([]string{name}, node, reflect.TypeOf(output), "config_name")
+	}
+	return v.err()
+}
+
+type validator struct {
+	config *Config
+	errors ConfigErrors
+
+	// sections are checked against the keys of inline maps, such as a
+	// binary's overrides, when strict is set
+	sections map[string]interface{}
+	strict   bool
+}
+
+func (v *validator) err() error {
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
+
+func (v *validator) errorf(path []string, node *yaml.Node, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if len(path) > 0 {
+		message = fmt.Sprintf("%s: %s", strings.Join(path, "."), message)
+	}
+
+	v.errors = append(v.errors, &ConfigError{
+		Source: Source{
+			Key:    strings.Join(path, "."),
+			File:   v.config.files[node],
+			Line:   node.Line,
+			Column: node.Column,
+		},
+		Message: message,
+	})
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", node.Value)
+}
+
+func (v *validator) validate(path []string, node *yaml.Node, t reflect.Type, tagName string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// null values leave the default in place
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(path, node, "Expected a mapping but got %s", nodeKind(node))
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if field, found := fieldByKey(t, key.Value, tagName); found {
+				v.validate(append(path, key.Value), value, field.Type, tagName)
+			} else if _, found := inlineField(t, tagName); found {
+				v.validateInline(path, key, value)
+			} else {
+				v.errorf(path, key, "Unknown key %q", key.Value)
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(path, node, "Expected a mapping but got %s", nodeKind(node))
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			v.validate(append(path, key.Value), value, t.Elem(), tagName)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(path, node, "Expected a list but got %s", nodeKind(node))
+			return
+		}
+
+		for _, item := range node.Content {
+			v.validate(path, item, t.Elem(), tagName)
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(path, node, "Expected %v but got %s", t, nodeKind(node))
+		} else if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			v.errorf(path, node, "Cannot use %q as %v", node.Value, t)
+		}
+	}
+}
+
+// validateInline checks a key collected by an inline map, such as a binary's
+// overrides.  When strict the key must be a config section and its value is
+// checked against the section
+func (v *validator) validateInline(path []string, key, value *yaml.Node) {
+	if !v.strict {
+		return
+	}
+
+	if section, found := v.sections[key.Value]; found && section != nil {
+		v.validate(append(path, key.Value), value, reflect.TypeOf(section), "config_name")
+	} else {
+		v.errorf(path, key, "Unknown key %q", key.Value)
+	}
+}
+
+// fieldByKey finds the struct field for key.  Fields are named by the given
+// struct tag, falling back to a case insensitive match of the field name
+// the same way the config decoder does
+func fieldByKey(t reflect.Type, key, tagName string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(tagName)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && (strings.Contains(tag, ",inline") || strings.Contains(tag, ",squash")) {
+			// fields of embedded structs are keys of the outer struct
+			if embedded, found := fieldByKey(field.Type, key, tagName); found {
+				embedded.Index = append(append([]int(nil), field.Index...), embedded.Index...)
+				return embedded, true
+			}
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || strings.Contains(field.Tag.Get(tagName), ",inline") {
+			continue
+		}
+
+		if name == key || (name == "" && strings.EqualFold(field.Name, key)) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// inlineField returns the map field that collects the keys of a struct that
+// don't belong to any other field
+func inlineField(t reflect.Type, tagName string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.Contains(field.Tag.Get(tagName), ",inline") && field.Type.Kind() == reflect.Map {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
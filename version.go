@@ -0,0 +1,110 @@
+package gack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/abates/gack/command"
+)
+
+// AutoVersion is the config version that asks for the version to be derived
+// from the repository
+const AutoVersion = "auto"
+
+var (
+	describePattern = regexp.MustCompile(`^(.+)-(\d+)-g([0-9a-f]+)(-dirty)?$`)
+	versionPattern  = regexp.MustCompile(`^\d+(\.\d+)*(-[0-9A-Za-z.]+)?(\+[0-9A-Za-z.]+)?$`)
+)
+
+// DeriveVersion computes a semantic version for the project in dir.  The
+// version comes from "git describe" when the repository has tags, and from
+// the content of the VERSION file otherwise.  A build that is not exactly
+// on a tag gets build metadata with the number of commits since the tag and
+// the commit hash, for instance 1.2.0+3.g1a2b3c4.dirty.  Packages use the
+// DebianVersion of derived versions
+func DeriveVersion(dir string) (string, error) {
+	// warnings on stderr are not part of the description
+	output, err := command.Run(&command.Command{Name: "git", Args: []string{"describe", "--tags", "--long", "--dirty"}, Dir: dir, Stderr: ioutil.Discard})
+
+	version := ""
+	if err == nil {
+		version, err = parseDescribe(strings.TrimSpace(output))
+	} else {
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Join(dir, "VERSION"))
+		if err != nil {
+			return "", fmt.Errorf("Could not derive version from git tags or a VERSION file: %v", err)
+		}
+		version = strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+	}
+
+	if err == nil && !versionPattern.MatchString(version) {
+		err = fmt.Errorf("Derived version %q is not a valid version", version)
+	}
+	return version, err
+}
+
+// ResolveVersion derives the version when the config's version is
+// AutoVersion.  Deriving the version runs git, so it is done once the config
+// is complete, after any profile has been applied
+func (c *Config) ResolveVersion() (err error) {
+	if c.Version == AutoVersion {
+		if c.Version, err = DeriveVersion(filepath.Dir(c.filename)); err == nil {
+			c.derivedVersion = true
+		}
+	}
+	return err
+}
+
+// PackageVersion returns the version to give packages.  Derived versions
+// are converted with DebianVersion, versions set in the config are used
+// as they are
+func (c *Config) PackageVersion() string {
+	if c.derivedVersion {
+		return DebianVersion(c.Version)
+	}
+	return c.Version
+}
+
+// DebianVersion converts a semantic version into a debian package version.
+// A hyphen in a debian version starts the package revision, so the
+// pre-release is separated with a tilde instead, which also sorts 1.2.3~rc1
+// before 1.2.3
+func DebianVersion(version string) string {
+	if i := strings.IndexAny(version, "-+"); i > -1 && version[i] == '-' {
+		version = version[:i] + "~" + version[i+1:]
+	}
+	return version
+}
+
+// parseDescribe converts the output of "git describe --tags --long --dirty"
+// into a semantic version
+func parseDescribe(describe string) (string, error) {
+	matches := describePattern.FindStringSubmatch(describe)
+	if matches == nil {
+		return "", fmt.Errorf("Unexpected git describe output %q", describe)
+	}
+
+	version := strings.TrimPrefix(matches[1], "v")
+	metadata := []string{}
+	if commits, _ := strconv.Atoi(matches[2]); commits > 0 {
+		metadata = append(metadata, matches[2], "g"+matches[3])
+	}
+
+	if matches[4] != "" {
+		metadata = append(metadata, "dirty")
+	}
+
+	if len(metadata) > 0 {
+		separator := "+"
+		if strings.Contains(version, "+") {
+			separator = "."
+		}
+		version = version + separator + strings.Join(metadata, ".")
+	}
+	return version, nil
+}